@@ -17,7 +17,7 @@ func main() {
 	// Load environment variables
 	cfg, err := config.Load()
 	if err != nil {
-		log.Println("Warning: .env file not found, using system env")
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
 	if cfg.Env == "production" {
@@ -30,13 +30,27 @@ func main() {
 	}
 	defer database.CloseDB()	
 
+	if cfg.RevocationStore == "redis" || cfg.ThrottleStore == "redis" {
+		if err := database.InitRedis(cfg); err != nil {
+			log.Fatalf("Failed to initialize redis: %v", err)
+		}
+		defer database.CloseRedis()
+	}
+
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		// Browsers only send cookies cross-origin when the exact origin is allowed, not "*"
+		if origin := allowedOrigin(cfg.CORSAllowOrigins, c.GetHeader("Origin")); origin != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		c.Writer.Header().Add("Vary", "Origin")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -78,4 +92,17 @@ func main() {
 	}
 
 	log.Println("Server exiting")
-}
\ No newline at end of file
+}
+
+// allowedOrigin returns the value of Access-Control-Allow-Origin for a request from origin
+func allowedOrigin(allowed []string, origin string) string {
+	for _, o := range allowed {
+		if o == "*" {
+			return "*"
+		}
+		if o == origin {
+			return origin
+		}
+	}
+	return ""
+}
@@ -1,30 +1,164 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/bharabhi01/authservice/internal/apikey"
 	"github.com/bharabhi01/authservice/internal/auth"
+	"github.com/bharabhi01/authservice/internal/mfa"
 	"github.com/bharabhi01/authservice/internal/middleware"
+	"github.com/bharabhi01/authservice/internal/oauth"
+	"github.com/bharabhi01/authservice/internal/passkey"
+	"github.com/bharabhi01/authservice/internal/reauth"
+	"github.com/bharabhi01/authservice/internal/session"
+	"github.com/bharabhi01/authservice/internal/token"
 	"github.com/bharabhi01/authservice/internal/user"
 	"github.com/bharabhi01/authservice/pkg/config"     
+	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/bharabhi01/authservice/pkg/denylist"
 	"github.com/bharabhi01/authservice/pkg/jwt" 
+	"github.com/bharabhi01/authservice/pkg/mailer"
+	"github.com/bharabhi01/authservice/pkg/password"
+	"github.com/bharabhi01/authservice/pkg/secretbox"
+	"github.com/bharabhi01/authservice/pkg/throttle"
 	"github.com/bharabhi01/authservice/pkg/audit"
 	auditHandler "github.com/bharabhi01/authservice/internal/audit"
 )
 
 func setupRoutes(router *gin.Engine, cfg *config.Config) {
-	jwt.Init(cfg.JWTSecret, cfg.JWTExpirationHours)
+	jwt.Init(cfg.JWTSecret, cfg.JWTExpirationHours, cfg.JWTAudience)
+	if err := jwt.InitKeys(cfg.JWTSigningAlg, cfg.JWTKeysDir, cfg.JWTActiveKeyID); err != nil {
+		log.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	if cfg.JWTKeyRotationHours > 0 && cfg.JWTSigningAlg != "HS256" {
+		jwt.StartKeyRotation(time.Duration(cfg.JWTKeyRotationHours) * time.Hour, nil)
+	}
+	// Picks up keys rotated in by other replicas sharing JWT_KEYS_DIR
+	jwt.StartKeyReload(time.Minute, nil)
+
+	passwords, err := password.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize password hashing: %v", err)
+	}
+
+	policy, err := password.NewPolicy(cfg, passwords)
+	if err != nil {
+		log.Fatalf("Failed to initialize password policy: %v", err)
+	}
+
+	mfaBox, err := secretbox.New(mfaEncryptionKey(cfg))
+	if err != nil {
+		log.Fatalf("Failed to initialize MFA encryption: %v", err)
+	}
 	
-	userRepo := user.NewRepository()
+	userRepo := user.NewRepository(passwords)
 	authRepo := auth.NewRepository()
+	tokenRepo := token.NewRepository()
+	// A session expires once it has gone unused for as long as its refresh tokens live
+	sessionRepo := session.NewRepository(time.Duration(cfg.RefreshExpirationDays) * 24 * time.Hour)
+	sessionRepo.StartPruning(time.Hour, nil)
+	apiKeyRepo := apikey.NewRepository()
+	oauthRepo := oauth.NewRepository()
+	mfaRepo := mfa.NewRepository(mfaBox)
+	passkeyRepo := passkey.NewRepository()
 	auditLogger := audit.NewLogger()
 
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+
+	relyingParty, err := passkey.NewRelyingParty(cfg, passkeyRepo, userRepo)
+	if err != nil {
+		log.Fatalf("Failed to initialize WebAuthn: %v", err)
+	}
+
+	if (cfg.RevocationStore == "redis" || cfg.ThrottleStore == "redis") && database.Redis == nil {
+		log.Fatalf("Redis is not initialized, but a store is configured to use it")
+	}
+
+	var revocations denylist.Store = denylist.NewMemoryStore()
+	if cfg.RevocationStore == "redis" {
+		revocations = denylist.NewRedisStore(database.Redis)
+	}
+
+	var attempts throttle.Store = throttle.NewMemoryStore()
+	if cfg.ThrottleStore == "redis" {
+		attempts = throttle.NewRedisStore(database.Redis)
+	}
+	limiter := throttle.NewLimiter(attempts, throttle.Policy{
+		FreeAttempts: cfg.LoginFreeAttempts,
+		BaseDelay: time.Duration(cfg.LoginBackoffSeconds) * time.Second,
+		MaxDelay: time.Duration(cfg.LoginMaxBackoffSeconds) * time.Second,
+		LockoutThreshold: cfg.LockoutThreshold,
+		LockoutDuration: time.Duration(cfg.LockoutMinutes) * time.Minute,
+	}, throttle.Policy{
+		FreeAttempts: cfg.IPFreeAttempts,
+		BaseDelay: time.Duration(cfg.LoginBackoffSeconds) * time.Second,
+		MaxDelay: time.Duration(cfg.LoginMaxBackoffSeconds) * time.Second,
+		LockoutThreshold: cfg.IPLockoutThreshold,
+		LockoutDuration: time.Duration(cfg.LockoutMinutes) * time.Minute,
+	})
+
+	authenticator := &middleware.Authenticator{
+		Revocations: revocations,
+		Sessions: sessionRepo,
+		APIKeys: apiKeyRepo,
+		CookieMode: cfg.AuthCookieMode,
+		AudiencelessUntil: cfg.AudiencelessTokensUntil,
+		Permissions: authRepo,
+	}
+
 	auditHandlerInstance := auditHandler.NewHandler(auditLogger)
-	authHandler := auth.NewHandler(userRepo, auditLogger)
-	roleHandler := auth.NewRoleHandler(authRepo)
+	authHandler := auth.NewHandler(userRepo, tokenRepo, sessionRepo, apiKeyRepo, mfaRepo, relyingParty, revocations, limiter, policy, mail, auditLogger, cfg)
+	roleHandler := auth.NewRoleHandler(authRepo, auth.ParseAssignmentRules(cfg.RoleAssignmentRules), auditLogger)
+	impersonationHandler := auth.NewImpersonationHandler(authRepo, userRepo, auditLogger, cfg)
+	sessionHandler := session.NewHandler(sessionRepo, tokenRepo, auditLogger)
+	apiKeyHandler := apikey.NewHandler(apiKeyRepo, authRepo, auditLogger)
+	verifier := reauth.NewVerifier(userRepo, limiter, auditLogger)
+	mfaHandler := mfa.NewHandler(mfaRepo, userRepo, verifier, auditLogger, cfg)
+	passkeyHandler := passkey.NewHandler(relyingParty, passkeyRepo, userRepo, verifier, mail, auditLogger)
+	clients := oauth.ClientStores{oauth.ParseStaticClients(cfg.OIDCClients), oauthRepo}
+	oauthHandler := oauth.NewHandler(userRepo, tokenRepo, sessionRepo, oauthRepo, clients, mfaRepo, relyingParty, revocations, limiter, auditLogger, cfg)
+	clientHandler := oauth.NewClientHandler(oauthRepo, auditLogger)
 
 	router.Use(middleware.AuditMiddleware(auditLogger))
 
-	public := router.Group("/api/v1")
+	// Public keys for services that verify our tokens on their own
+	router.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(200, jwt.JWKS())
+	})
+
+	// OpenID Connect provider
+	// Clients verify ID tokens with the JWKS, which is empty while tokens are signed with the shared HS256 secret
+	if jwt.Asymmetric() {
+		router.GET("/.well-known/openid-configuration", oauthHandler.Discovery)
+		oauth2 := router.Group("/oauth2")
+		{
+			oauth2.GET("/authorize", oauthHandler.Authorize)
+			oauth2.POST("/authorize", oauthHandler.Authorize)
+			oauth2.POST("/token", oauthHandler.Token)
+			oauth2.POST("/introspect", oauthHandler.Introspect)
+			oauth2.POST("/revoke", oauthHandler.Revoke)
+		}
+		router.GET("/userinfo", middleware.AuthMiddleware(authenticator), middleware.RequireScopes("openid"), oauthHandler.UserInfo)
+		router.POST("/userinfo", middleware.AuthMiddleware(authenticator), middleware.RequireScopes("openid"), oauthHandler.UserInfo)
+	} else {
+		log.Printf("OpenID Connect provider disabled: set JWT_SIGNING_ALG to RS256, ES256 or EdDSA to enable it")
+	}
+
+	// Requests authenticated by cookie must echo the CSRF token on state-changing methods
+	api := router.Group("/api/v1")
+	if cfg.AuthCookieMode {
+		api.Use(middleware.CSRFMiddleware())
+	}
+
+	public := api.Group("")
 	{
 		public.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{
@@ -37,40 +171,127 @@ func setupRoutes(router *gin.Engine, cfg *config.Config) {
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/verify-email/resend", authHandler.ResendVerificationEmail)
+			auth.POST("/password/forgot", authHandler.ForgotPassword)
+			auth.POST("/password/reset", authHandler.ResetPassword)
+			auth.POST("/mfa/verify", authHandler.VerifyMFA)
+			auth.POST("/mfa/webauthn/begin", authHandler.BeginMFAWebAuthn)
+			auth.POST("/mfa/webauthn/finish", authHandler.FinishMFAWebAuthn)
+			auth.POST("/passkey/login/begin", authHandler.BeginPasskeyLogin)
+			auth.POST("/passkey/login/finish", authHandler.FinishPasskeyLogin)
 		}
 	}
 
-	protected := router.Group("/api/v1")
-	protected.Use(middleware.AuthMiddleware())
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware(authenticator))
 	{
+		authentication := protected.Group("/auth")
+		{
+			authentication.POST("/logout", authHandler.Logout)
+		}
+
 		users := protected.Group("/users")
 		{
 			users.GET("/userinfo", authHandler.CurrentUserInfo)
 
-			users.GET("/:id/roles", roleHandler.GetUserRoles)
-			users.POST("/:id/roles", roleHandler.AssignRoleToUser)
-			users.DELETE("/:id/roles/:roleId", roleHandler.RemoveRoleFromUser)
+			users.GET("", middleware.RequirePermission("user:read"), authHandler.ListUsers)
+			users.POST("/import", middleware.RequirePermission("user:write"), authHandler.ImportUsers)
+			users.GET("/:id", middleware.RequirePermission("user:read"), authHandler.GetUser)
+			// Endpoints changing a user refuse users holding permissions the caller lacks
+			users.PATCH("/:id", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), authHandler.UpdateUser)
+			users.DELETE("/:id", middleware.RequirePermission("user:delete"), middleware.RequireOutranks("id"), authHandler.DeleteUser)
+			users.POST("/:id/activate", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), authHandler.ActivateUser)
+			users.POST("/:id/deactivate", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), authHandler.DeactivateUser)
+			users.DELETE("/:id/mfa", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), mfaHandler.ResetUserMFA)
+			users.POST("/:id/unlock", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), authHandler.UnlockUser)
+
+			// Account management needs a login token: API keys are refused outright,
+			// tokens issued to OAuth2 clients need the account scope
+			me := users.Group("/me")
+			me.Use(middleware.DenyAPIKey(), middleware.RequireScopes("account"))
+			{
+				me.PATCH("", middleware.DenyImpersonation(), authHandler.UpdateProfile)
+				me.PUT("/password", middleware.DenyImpersonation(), authHandler.ChangePassword)
+				me.GET("/mfa", mfaHandler.GetStatus)
+				me.POST("/mfa/totp", middleware.DenyImpersonation(), mfaHandler.EnrollTOTP)
+				me.POST("/mfa/totp/confirm", middleware.DenyImpersonation(), mfaHandler.ConfirmTOTP)
+				me.POST("/mfa/recovery-codes", middleware.DenyImpersonation(), mfaHandler.RegenerateRecoveryCodes)
+				me.DELETE("/mfa", middleware.DenyImpersonation(), mfaHandler.DisableMFA)
+				me.GET("/passkeys", passkeyHandler.GetPasskeys)
+				me.POST("/passkeys/register/begin", middleware.DenyImpersonation(), passkeyHandler.BeginRegistration)
+				me.POST("/passkeys/register/finish", middleware.DenyImpersonation(), passkeyHandler.FinishRegistration)
+				me.DELETE("/passkeys/:passkeyId", middleware.DenyImpersonation(), passkeyHandler.DeletePasskey)
+				me.GET("/sessions", sessionHandler.GetMySessions)
+				me.DELETE("/sessions/:sid", sessionHandler.RevokeMySession)
+				me.GET("/api-keys", apiKeyHandler.GetAPIKeys)
+				me.POST("/api-keys", middleware.DenyImpersonation(), apiKeyHandler.CreateAPIKey)
+				me.DELETE("/api-keys/:keyId", apiKeyHandler.RevokeAPIKey)
+			}
 
-			users.GET("/:id/permissions/check", roleHandler.CheckPermission)
+			users.GET("/:id/sessions", middleware.RequirePermission("user:read"), sessionHandler.GetUserSessions)
+			users.DELETE("/:id/sessions/:sid", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), sessionHandler.RevokeUserSession)
+
+			// Role management is out of reach of impersonation tokens
+			users.GET("/:id/roles", middleware.DenyImpersonation(), middleware.RequirePermission("role:read"), roleHandler.GetUserRoles)
+			users.POST("/:id/roles", middleware.DenyImpersonation(), middleware.RequirePermission("role:write"), roleHandler.AssignRoleToUser)
+			users.DELETE("/:id/roles/:roleId", middleware.DenyImpersonation(), middleware.RequirePermission("role:write"), roleHandler.RemoveRoleFromUser)
+
+			users.GET("/:id/permissions/check", middleware.RequireAnyPermission("user:read", "role:read"), roleHandler.CheckPermission)
+
+			users.POST("/:id/tokens/revoke", middleware.RequirePermission("user:write"), middleware.RequireOutranks("id"), authHandler.RevokeUserTokens)
+			users.POST("/:id/impersonate", middleware.DenyImpersonation(), middleware.RequirePermission("user:impersonate"), impersonationHandler.Impersonate)
 		}
 
 		roles := protected.Group("/roles")
-		roles.Use(middleware.RoleMiddleware("admin")) 
+		roles.Use(middleware.DenyImpersonation())
 		{
-			roles.GET("", roleHandler.GetRoles)
+			roles.GET("", middleware.RequirePermission("role:read"), roleHandler.GetRoles)
+			roles.POST("", middleware.RequirePermission("role:write"), roleHandler.CreateRole)
+			roles.PATCH("/:id", middleware.RequirePermission("role:write"), roleHandler.UpdateRole)
+			roles.DELETE("/:id", middleware.RequirePermission("role:delete"), roleHandler.DeleteRole)
+			roles.PUT("/:id/permissions/:permId", middleware.RequirePermission("role:write"), roleHandler.AddPermissionToRole)
+			roles.DELETE("/:id/permissions/:permId", middleware.RequirePermission("role:write"), roleHandler.RemovePermissionFromRole)
 		}
 
 		permissions := protected.Group("/permissions")
-		permissions.Use(middleware.RoleMiddleware("admin")) 
+		permissions.Use(middleware.DenyImpersonation(), middleware.RequirePermission("role:read"))
 		{
 			permissions.GET("", roleHandler.GetPermissions)
 		}
 
+		oauthClients := protected.Group("/clients")
+		{
+			oauthClients.GET("", middleware.RequirePermission("client:read"), clientHandler.GetClients)
+			oauthClients.POST("", middleware.RequirePermission("client:write"), clientHandler.CreateClient)
+			oauthClients.GET("/:id", middleware.RequirePermission("client:read"), clientHandler.GetClient)
+			oauthClients.PATCH("/:id", middleware.RequirePermission("client:write"), clientHandler.UpdateClient)
+			oauthClients.DELETE("/:id", middleware.RequirePermission("client:delete"), clientHandler.DeleteClient)
+			oauthClients.POST("/:id/secret", middleware.RequirePermission("client:write"), clientHandler.RotateClientSecret)
+		}
+
 		// Add audit logs endpoint
 		logs := protected.Group("/audit")
-		logs.Use(middleware.RoleMiddleware("admin"))
+		logs.Use(middleware.RequirePermission("audit:read"))
 		{
 			logs.GET("/logs", auditHandlerInstance.GetLogs)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// mfaEncryptionKey decodes MFA_ENCRYPTION_KEY
+// In development a key derived from JWT_SECRET is used when it is not set, config.Load refuses that elsewhere
+func mfaEncryptionKey(cfg *config.Config) []byte {
+	if cfg.MFAEncryptionKey == "" && cfg.Env == "development" {
+		log.Println("Warning: MFA_ENCRYPTION_KEY is not set, deriving it from JWT_SECRET")
+		key := sha256.Sum256([]byte("mfa:" + cfg.JWTSecret))
+		return key[:]
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.MFAEncryptionKey)
+	if err != nil {
+		log.Fatalf("MFA_ENCRYPTION_KEY must be base64 encoded: %v", err)
+	}
+	return key
+}
@@ -0,0 +1,171 @@
+package apikey
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// PermissionChecker resolves whether a user holds a permission
+// auth.Repository satisfies it
+type PermissionChecker interface {
+	HasPermission(userID, permissionName string) (bool, error)
+}
+
+// Handler handles API key management requests for the current user
+type Handler struct {
+	apiKeyRepo  *Repository
+	permissions PermissionChecker
+	auditLogger *audit.Logger
+}
+
+func NewHandler(apiKeyRepo *Repository, permissions PermissionChecker, auditLogger *audit.Logger) *Handler {
+	return &Handler{
+		apiKeyRepo:  apiKeyRepo,
+		permissions: permissions,
+		auditLogger: auditLogger,
+	}
+}
+
+// CreateAPIKey issues a new API key limited to a subset of the user's permissions
+// The key is only returned in this response
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	// A key must not be able to mint further keys
+	if c.GetString("apiKeyID") != "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "API keys cannot be created with an API key",
+		})
+		return
+	}
+
+	var creation APIKeyCreation
+	if err := c.ShouldBindJSON(&creation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid API key data: " + err.Error(),
+		})
+		return
+	}
+
+	if creation.ExpiresAt != nil && !creation.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expires_at must be in the future",
+		})
+		return
+	}
+
+	for _, scope := range creation.Scopes {
+		hasPermission, err := h.permissions.HasPermission(userID.(string), scope)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check permission",
+			})
+			return
+		}
+		if !hasPermission {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "You do not have the permission " + scope,
+			})
+			return
+		}
+	}
+
+	key, plaintext, err := h.apiKeyRepo.Create(userID.(string), &creation)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"api_key_id": key.ID,
+			"name":       key.Name,
+			"scopes":     key.Scopes,
+		}
+		h.auditLogger.LogFromGin(c, "API_KEY_CREATED", "user", key.UserID, details)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created successfully",
+		"api_key": key,
+		"key":     plaintext,
+	})
+}
+
+// GetAPIKeys lists the current user's API keys without their secret part
+func (h *Handler) GetAPIKeys(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	keys, err := h.apiKeyRepo.GetByUser(userID.(string))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get API keys",
+		})
+		return
+	}
+
+	if keys == nil {
+		keys = []APIKey{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+	})
+}
+
+// RevokeAPIKey revokes one of the current user's API keys
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	keyID := c.Param("keyId")
+	if err := h.apiKeyRepo.Revoke(userID.(string), keyID); err != nil {
+		if err == ErrKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "API key not found",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke API key",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"api_key_id": keyID,
+		}
+		h.auditLogger.LogFromGin(c, "API_KEY_REVOKED", "user", userID.(string), details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key revoked successfully",
+	})
+}
@@ -0,0 +1,50 @@
+package apikey
+
+import (
+	"strings"
+	"time"
+)
+
+// Every key starts with this marker so AuthMiddleware can tell it apart from a JWT
+const KeyPrefix = "ak_"
+
+// API keys table in the database
+// KeyHash holds the SHA-256 hash of the key, which is only shown once at creation
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Owner of a key as loaded together with it during authentication
+type Owner struct {
+	UserID   string
+	Username string
+	Roles    []string
+	Active   bool
+}
+
+// For input validation when creating a key
+// Scopes are permission names, and must be a subset of the user's own permissions
+type APIKeyCreation struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// IsActive reports whether the key can still be used
+func (k *APIKey) IsActive() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// IsAPIKey reports whether a credential looks like an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, KeyPrefix)
+}
@@ -0,0 +1,202 @@
+package apikey
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrKeyNotFound = errors.New("api key not found")
+	ErrKeyInactive = errors.New("api key is expired or revoked")
+)
+
+// last_used_at is only written when it is older than this
+const touchInterval = time.Minute
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		db: database.DB,
+	}
+}
+
+// Create issues a new API key for a user and returns the plaintext key
+func (r *Repository) Create(userID string, creation *APIKeyCreation) (*APIKey, string, error) {
+	secret, err := token.RandomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := KeyPrefix + secret
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      creation.Name,
+		Prefix:    plaintext[:len(KeyPrefix)+8],
+		KeyHash:   token.HashToken(plaintext),
+		Scopes:    creation.Scopes,
+		ExpiresAt: creation.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	err = r.db.QueryRow(
+		query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+		key.CreatedAt,
+	).Scan(&key.ID)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, plaintext, nil
+}
+
+// GetByUser returns the keys of a user that have not been revoked
+func (r *Repository) GetByUser(userID string) ([]APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			&key.KeyHash,
+			pq.Array(&key.Scopes),
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+			&key.RevokedAt,
+			&key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Authenticate looks up an API key together with its owner and records that it was used
+// It returns ErrKeyNotFound for unknown keys and ErrKeyInactive for expired or revoked ones
+func (r *Repository) Authenticate(plaintext string) (*APIKey, *Owner, error) {
+	key := &APIKey{}
+	owner := &Owner{}
+
+	query := `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.expires_at, k.last_used_at, k.revoked_at, k.created_at,
+			u.username, u.active,
+			ARRAY(SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id ORDER BY r.name)
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+	`
+
+	err := r.db.QueryRow(query, token.HashToken(plaintext)).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+		&owner.Username,
+		&owner.Active,
+		pq.Array(&owner.Roles),
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrKeyNotFound
+		}
+		return nil, nil, err
+	}
+	owner.UserID = key.UserID
+
+	if !key.IsActive() {
+		return key, owner, ErrKeyInactive
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchInterval {
+		if _, err := r.db.Exec(`UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, key.ID, now); err != nil {
+			return nil, nil, err
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, owner, nil
+}
+
+// Revoke revokes a key of a user
+// It returns ErrKeyNotFound if the key does not belong to the user or was already revoked
+func (r *Repository) Revoke(userID, id string) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrKeyNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every key of a user
+func (r *Repository) RevokeAllForUser(userID string) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, userID, time.Now())
+	return err
+}
@@ -20,6 +20,7 @@ func NewHandler(auditLogger *audit.Logger) *Handler {
 
 func (h *Handler) GetLogs(c *gin.Context) {
 	userID := c.Query("user_id")
+	actorID := c.Query("actor_id")
 	action := c.Query("action")
 	resourceType := c.Query("resource_type")
 
@@ -37,10 +38,11 @@ func (h *Handler) GetLogs(c *gin.Context) {
 		}
 	}
 
-	logs, err := h.auditLogger.GetLogs(c.Request.Context(), userID, action, resourceType, limit, offset)
+	logs, err := h.auditLogger.GetLogs(c.Request.Context(), userID, actorID, action, resourceType, limit, offset)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get audit logs: " + err.Error(),
+			"error": "Failed to get audit logs",
 		})
 		return
 	}
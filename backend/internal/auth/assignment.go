@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"strings"
+)
+
+// anyRole in an assignment rule lets the granting role assign every role
+const anyRole = "*"
+
+// AssignmentRules says which roles may assign which others to users, keyed by the granting role
+// A user may assign or remove a role when any of their own roles allows it
+type AssignmentRules map[string]map[string]bool
+
+// ParseAssignmentRules reads rules in the form "granter:role1|role2", as configured in ROLE_ASSIGNMENT_RULES
+// Malformed entries are skipped, which only ever takes rights away
+func ParseAssignmentRules(entries []string) AssignmentRules {
+	rules := AssignmentRules{}
+
+	for _, entry := range entries {
+		granter, roles, found := strings.Cut(entry, ":")
+		granter = strings.TrimSpace(granter)
+		if !found || granter == "" {
+			continue
+		}
+
+		if rules[granter] == nil {
+			rules[granter] = map[string]bool{}
+		}
+		for _, role := range strings.Split(roles, "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				rules[granter][role] = true
+			}
+		}
+	}
+
+	return rules
+}
+
+// CanAssign reports whether a user holding granterRoles may assign role to, or remove it from, a user
+func (r AssignmentRules) CanAssign(granterRoles []string, role string) bool {
+	for _, granter := range granterRoles {
+		if r[granter][anyRole] || r[granter][role] {
+			return true
+		}
+	}
+	return false
+}
+
+// Mentions reports whether role appears in the rules, as a granting role or as a role to assign
+// The rules name roles, so renaming one of them would change what the rules allow
+func (r AssignmentRules) Mentions(role string) bool {
+	if _, granter := r[role]; granter {
+		return true
+	}
+	for _, roles := range r {
+		if roles[role] {
+			return true
+		}
+	}
+	return false
+}
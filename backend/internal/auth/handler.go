@@ -1,26 +1,158 @@
 package auth
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/bharabhi01/authservice/internal/apikey"
+	"github.com/bharabhi01/authservice/internal/mfa"
+	"github.com/bharabhi01/authservice/internal/passkey"
+	"github.com/bharabhi01/authservice/internal/reauth"
+	"github.com/bharabhi01/authservice/internal/session"
+	"github.com/bharabhi01/authservice/internal/token"
 	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/authcookie"
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/bharabhi01/authservice/pkg/denylist"
 	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/bharabhi01/authservice/pkg/mailer"
+	"github.com/bharabhi01/authservice/pkg/password"
+	"github.com/bharabhi01/authservice/pkg/throttle"
 	"github.com/bharabhi01/authservice/pkg/audit"
 )
 
 type Handler struct {
 	userRepo *user.Repository
+	tokenRepo *token.Repository
+	sessionRepo *session.Repository
+	apiKeyRepo *apikey.Repository
+	mfaRepo *mfa.Repository
+	passkeys *passkey.RelyingParty
+	revocations denylist.Store
+	limiter *throttle.Limiter
+	verifier *reauth.Verifier
+	policy *password.Policy
+	mailer mailer.Mailer
 	auditLogger *audit.Logger
+	cfg *config.Config
 }
 
-func NewHandler(userRepo *user.Repository, auditLogger *audit.Logger) *Handler {
+func NewHandler(userRepo *user.Repository, tokenRepo *token.Repository, sessionRepo *session.Repository, apiKeyRepo *apikey.Repository, mfaRepo *mfa.Repository, passkeys *passkey.RelyingParty, revocations denylist.Store, limiter *throttle.Limiter, policy *password.Policy, mailer mailer.Mailer, auditLogger *audit.Logger, cfg *config.Config) *Handler {
 	return &Handler{
 		userRepo: userRepo,
+		tokenRepo: tokenRepo,
+		sessionRepo: sessionRepo,
+		apiKeyRepo: apiKeyRepo,
+		mfaRepo: mfaRepo,
+		passkeys: passkeys,
+		revocations: revocations,
+		limiter: limiter,
+		verifier: reauth.NewVerifier(userRepo, limiter, auditLogger),
+		policy: policy,
+		mailer: mailer,
 		auditLogger: auditLogger,
+		cfg: cfg,
 	}
 }
 
+// writeTokens adds the tokens to a response body
+// In cookie mode they are set as HttpOnly cookies instead, and only the CSRF token is returned
+func (h *Handler) writeTokens(c *gin.Context, tokens gin.H, response gin.H) error {
+	if !h.cfg.AuthCookieMode {
+		for k, v := range tokens {
+			response[k] = v
+		}
+		return nil
+	}
+
+	csrfToken, err := authcookie.SetTokens(c, h.cfg, tokens["token"].(string), tokens["refresh_token"].(string))
+	if err != nil {
+		return err
+	}
+	response["csrf_token"] = csrfToken
+	response["expires_in"] = tokens["expires_in"]
+	return nil
+}
+
+// issueTokens starts a session for a user and creates its access token and refresh token
+func (h *Handler) issueTokens(c *gin.Context, u *user.User) (gin.H, error) {
+	s, err := h.sessionRepo.Create(u.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := jwt.Issue(&jwt.Claims{
+		UserId: u.ID,
+		Username: u.Username,
+		Roles: u.Roles,
+		SessionId: s.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := h.tokenRepo.Create(&token.RefreshToken{
+		UserID: u.ID,
+		SessionID: s.ID,
+		ExpiresAt: h.refreshExpiry(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token": accessToken,
+		"refresh_token": refreshToken,
+		"token_type": "Bearer",
+		"expires_in": h.cfg.JWTExpirationHours * 3600,
+	}, nil
+}
+
+func (h *Handler) refreshExpiry() time.Time {
+	return time.Now().Add(time.Duration(h.cfg.RefreshExpirationDays) * 24 * time.Hour)
+}
+
+// checkPasswordPolicy answers 400 with every violated rule when a new password is not acceptable
+// The password history is only checked for existing users, it returns false when the request was answered
+func (h *Handler) checkPasswordPolicy(c *gin.Context, candidate string, u *user.User) bool {
+	subject := password.Subject{
+		Username: u.Username,
+		Email: u.Email,
+	}
+	if u.ID != "" {
+		history, err := h.userRepo.PasswordHistory(u, h.policy.HistorySize)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check password",
+			})
+			return false
+		}
+		subject.History = history
+	}
+
+	violations, err := h.policy.Check(candidate, subject)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check password",
+		})
+		return false
+	}
+
+	if len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Password does not meet the password policy",
+			"violations": violations,
+		})
+		return false
+	}
+
+	return true
+}
+
 func (h *Handler) Register(c *gin.Context) {
 	var registration user.UserRegistration
 	if err := c.ShouldBindJSON(&registration); err != nil {
@@ -38,18 +170,19 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	newUser, err := h.userRepo.Create(&registration)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create user: " + err.Error(),
-		})
+	candidate := &user.User{
+		Username: registration.Username,
+		Email: registration.Email,
+	}
+	if !h.checkPasswordPolicy(c, registration.Password, candidate) {
 		return
 	}
 
-	token, err := jwt.GenerateToken(newUser.ID, newUser.Username, newUser.Role)
+	newUser, err := h.userRepo.Create(&registration)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to generate token: " + err.Error(),
+			"error": "Failed to create user",
 		})
 		return
 	}
@@ -62,11 +195,41 @@ func (h *Handler) Register(c *gin.Context) {
 		h.auditLogger.LogFromGin(c, "REGISTER", "user", newUser.ID, details)
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	// The account exists either way, so a failed email only means the user has to ask for another one
+	if err := h.sendVerificationEmail(c.Request.Context(), newUser); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", newUser.ID, err)
+	}
+
+	if h.cfg.RequireEmailVerification {
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "User registered successfully, check your email to verify your account",
+			"user": newUser.ToResponse(),
+		})
+		return
+	}
+
+	tokens, err := h.issueTokens(c, newUser)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate token",
+		})
+		return
+	}
+
+	response := gin.H{
 		"message": "User registered successfully",
 		"user": newUser.ToResponse(),
-		"token": token,
-	})
+	}
+	if err := h.writeTokens(c, tokens, response); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to set cookies",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *Handler) Login(c *gin.Context) {
@@ -78,48 +241,210 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userRepo.GetByUsername(login.Username)
+	attempt, ok := h.checkLoginThrottle(c, login.Username)
+	if !ok {
+		return
+	}
+
+	u, err := h.userRepo.Authenticate(login.Username, login.Password)
 	if err != nil {
+		if err == user.ErrInactive {
+			h.releaseLoginAttempt(c, login.Username)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Account is not active",
+			})
+			return
+		}
+		h.recordLoginFailure(c, login.Username, "invalid_credentials", attempt)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Invalid credentials",
 		})
 		return
 	}
+	h.releaseLoginAttempt(c, login.Username)
 
-	if !h.userRepo.VerifyPassword(user, login.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid credentials",
+	if h.cfg.RequireEmailVerification && !u.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Email address is not verified",
 		})
 		return
 	}
 
-	if !user.Active {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "Account is not active",
+	// Users with MFA or a passkey only get a token for the second step, exchanged at /auth/mfa/verify
+	methods, err := h.secondFactors(u)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get MFA status",
 		})
 		return
 	}
+	if len(methods) > 0 {
+		h.requireMFA(c, u, methods)
+		return
+	}
 
-	token, err := jwt.GenerateToken(user.ID, user.Username, user.Role)
+	h.completeLogin(c, u, nil)
+}
+
+// completeLogin issues the tokens of a user who passed every login step and audits the login
+func (h *Handler) completeLogin(c *gin.Context, u *user.User, details map[string]interface{}) {
+	tokens, err := h.issueTokens(c, u)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to generate token: " + err.Error(),
+			"error": "Failed to generate token",
 		})
 		return
 	}
 
+	if err := h.limiter.Succeed(c.Request.Context(), u.Username); err != nil {
+		log.Printf("Failed to reset login failures of user %s: %v", u.ID, err)
+	}
+
 	if h.auditLogger != nil {
-		details := map[string]interface{}{
-			"username": user.Username,
+		if details == nil {
+			details = map[string]interface{}{}
 		}
-		h.auditLogger.LogFromGin(c, "LOGIN", "user", user.ID, details)
+		details["username"] = u.Username
+		h.auditLogger.LogFromGin(c, "LOGIN", "user", u.ID, details)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Login successful",
-		"user": user.ToResponse(),
-		"token": token,
+		"user": u.ToResponse(),
+	}
+	if err := h.writeTokens(c, tokens, response); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to set cookies",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Refresh exchanges a refresh token for a new access token and a rotated refresh token
+// Presenting a refresh token that was already used revokes every token of that login
+func (h *Handler) Refresh(c *gin.Context) {
+	var request token.RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid refresh request: " + err.Error(),
+		})
+		return
+	}
+
+	if request.RefreshToken == "" && h.cfg.AuthCookieMode {
+		request.RefreshToken, _ = c.Cookie(authcookie.RefreshTokenName)
+	}
+	if request.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid refresh request: refresh_token is required",
+		})
+		return
+	}
+
+	// Tokens issued to an OAuth2 client are refreshed at /oauth2/token, where the client authenticates
+	if current, err := h.tokenRepo.GetByToken(request.RefreshToken); err == nil && current.ClientID != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Refresh token was issued to an OAuth2 client",
+		})
+		return
+	}
+
+	refreshToken, next, err := h.tokenRepo.Rotate(request.RefreshToken, h.refreshExpiry())
+	if err != nil {
+		switch err {
+		case token.ErrTokenReused:
+			// A stolen refresh token may already have produced access tokens, so end the whole session
+			if next.SessionID != "" {
+				h.sessionRepo.RevokeByID(next.SessionID)
+			}
+			if h.auditLogger != nil {
+				details := map[string]interface{}{
+					"family_id": next.FamilyID,
+				}
+				h.auditLogger.LogFromGin(c, "REFRESH_TOKEN_REUSE", "user", next.UserID, details)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Refresh token has already been used",
+			})
+		case token.ErrTokenNotFound, token.ErrTokenExpired:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid or expired refresh token",
+			})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to refresh token",
+			})
+		}
+		return
+	}
+
+	user, err := h.userRepo.GetByID(next.UserID)
+	if err != nil || !user.Active {
+		h.tokenRepo.RevokeFamily(next.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Account is not active",
+		})
+		return
+	}
+
+	// Refreshing keeps the session alive, unless it was revoked or has expired in the meantime
+	if next.SessionID != "" {
+		active, err := h.sessionRepo.Validate(next.SessionID)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check session",
+			})
+			return
+		}
+		if !active {
+			h.tokenRepo.RevokeFamily(next.FamilyID)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Session has expired or been revoked",
+			})
+			return
+		}
+	}
+
+	// Tokens refreshed from an OAuth2 grant keep the scope and audience they were granted
+	accessToken, err := jwt.Issue(&jwt.Claims{
+		UserId: user.ID,
+		Username: user.Username,
+		Roles: user.Roles,
+		Scope: next.Scope,
+		Audience: next.Audience,
+		SessionId: next.SessionID,
 	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate token",
+		})
+		return
+	}
+
+	response := gin.H{}
+	tokens := gin.H{
+		"token": accessToken,
+		"refresh_token": refreshToken,
+		"token_type": "Bearer",
+		"expires_in": h.cfg.JWTExpirationHours * 3600,
+	}
+	if err := h.writeTokens(c, tokens, response); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to set cookies",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *Handler) CurrentUserInfo(c *gin.Context) {
@@ -133,8 +458,9 @@ func (h *Handler) CurrentUserInfo(c *gin.Context) {
 
 	user, err := h.userRepo.GetByID(userID.(string))
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get user: " + err.Error(),
+			"error": "Failed to get user",
 		})
 		return
 	}
@@ -144,3 +470,126 @@ func (h *Handler) CurrentUserInfo(c *gin.Context) {
 	})
 }
 
+// Logout revokes the access token used for this request
+// If a refresh token is given, every token of that login is revoked as well
+func (h *Handler) Logout(c *gin.Context) {
+	value, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+	claims := value.(*jwt.Claims)
+
+	var request token.LogoutRequest
+	if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid logout request: " + err.Error(),
+		})
+		return
+	}
+
+	if request.RefreshToken == "" && h.cfg.AuthCookieMode {
+		request.RefreshToken, _ = c.Cookie(authcookie.RefreshTokenName)
+	}
+
+	// Requests made with an API key carry no token to revoke
+	if claims.Id != "" {
+		if err := h.revocations.Revoke(c.Request.Context(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to revoke token",
+			})
+			return
+		}
+	}
+
+	if claims.SessionId != "" {
+		if err := h.endSession(claims.SessionId); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to end session",
+			})
+			return
+		}
+	}
+
+	if request.RefreshToken != "" {
+		refreshToken, err := h.tokenRepo.GetByToken(request.RefreshToken)
+		if err == nil && refreshToken.UserID == claims.UserId {
+			if err := h.tokenRepo.RevokeFamily(refreshToken.FamilyID); err != nil {
+				c.Error(err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to revoke refresh token",
+				})
+				return
+			}
+		}
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"username": claims.Username,
+		}
+		h.auditLogger.LogFromGin(c, "LOGOUT", "user", claims.UserId, details)
+	}
+
+	if h.cfg.AuthCookieMode {
+		authcookie.Clear(c, h.cfg)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logout successful",
+	})
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to a user
+// This endpoint is meant for admins, e.g. when an account is compromised
+func (h *Handler) RevokeUserTokens(c *gin.Context) {
+	userID := c.Param("id")
+	if !validID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	if err := h.revokeAllTokens(c, userID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke tokens",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		h.auditLogger.LogFromGin(c, "TOKENS_REVOKED", "user", userID, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "All tokens revoked successfully",
+	})
+}
+
+// revokeAllTokens denies the outstanding access tokens of a user and revokes their refresh tokens, sessions and API keys
+func (h *Handler) revokeAllTokens(c *gin.Context, userID string) error {
+	if err := h.revocations.RevokeUser(c.Request.Context(), userID, time.Now(), jwt.Lifetime()); err != nil {
+		return err
+	}
+	if err := h.apiKeyRepo.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+	if err := h.sessionRepo.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+	return h.tokenRepo.RevokeAllForUser(userID)
+}
+
+// endSession revokes a session together with the refresh tokens issued within it
+func (h *Handler) endSession(sessionID string) error {
+	if err := h.sessionRepo.RevokeByID(sessionID); err != nil {
+		return err
+	}
+	return h.tokenRepo.RevokeSession(sessionID)
+}
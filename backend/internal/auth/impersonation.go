@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// Permission required to act as another user
+const PermissionImpersonate = "user:impersonate"
+
+// ImpersonationHandler lets support staff obtain a token acting as another user
+type ImpersonationHandler struct {
+	authRepo    *Repository
+	userRepo    *user.Repository
+	auditLogger *audit.Logger
+	cfg         *config.Config
+}
+
+// NewImpersonationHandler creates a new impersonation handler
+func NewImpersonationHandler(authRepo *Repository, userRepo *user.Repository, auditLogger *audit.Logger, cfg *config.Config) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		authRepo:    authRepo,
+		userRepo:    userRepo,
+		auditLogger: auditLogger,
+		cfg:         cfg,
+	}
+}
+
+// Impersonate issues a short-lived token for the user in the path
+// The token carries the user's identity and an act claim naming the admin who asked for it
+// Only users whose permissions the admin holds as well can be impersonated
+func (h *ImpersonationHandler) Impersonate(c *gin.Context) {
+	actorID := c.GetString("userID")
+	if actorID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	if c.GetString("apiKeyID") != "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "API keys cannot be used to impersonate users",
+		})
+		return
+	}
+
+	allowed, err := h.authRepo.HasPermission(actorID, PermissionImpersonate)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check permission",
+		})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Insufficient permissions",
+		})
+		return
+	}
+
+	var request ImpersonationRequest
+	if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid impersonation request: " + err.Error(),
+		})
+		return
+	}
+
+	targetID := c.Param("id")
+	if targetID == actorID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "You cannot impersonate yourself",
+		})
+		return
+	}
+
+	target, err := h.userRepo.GetByID(targetID)
+	if err != nil {
+		if err == user.ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "User not found",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if !target.Active {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "User account is inactive",
+		})
+		return
+	}
+
+	// Acting as another impersonator would hand out their permission
+	targetCanImpersonate, err := h.authRepo.HasPermission(target.ID, PermissionImpersonate)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check permission",
+		})
+		return
+	}
+	if targetCanImpersonate {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Users who can impersonate others cannot be impersonated",
+		})
+		return
+	}
+
+	// Acting as a user with more permissions would hand those out too, the rule role assignment follows as well
+	outranks, err := h.outranks(actorID, target.ID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check permission",
+		})
+		return
+	}
+	if !outranks {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You cannot impersonate a user who holds permissions you do not hold",
+		})
+		return
+	}
+
+	lifetime := time.Duration(h.cfg.ImpersonationTokenMinutes) * time.Minute
+	claims := &jwt.Claims{
+		UserId:   target.ID,
+		Username: target.Username,
+		Roles:    target.Roles,
+		Actor: &jwt.Actor{
+			Subject:  actorID,
+			Username: c.GetString("username"),
+		},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(lifetime).Unix(),
+		},
+	}
+
+	accessToken, err := jwt.Issue(claims)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate token",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"target_user_id":  target.ID,
+			"target_username": target.Username,
+			"reason":          request.Reason,
+			"jti":             claims.Id,
+			"expires_at":      time.Unix(claims.ExpiresAt, 0),
+		}
+		h.auditLogger.LogFromGin(c, "IMPERSONATION_STARTED", "user", target.ID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Impersonation token issued",
+		"token":      accessToken,
+		"token_type": "Bearer",
+		"expires_in": int(lifetime.Seconds()),
+		"user":       target.ToResponse(),
+	})
+}
+
+// outranks reports whether the actor holds every permission the target holds
+func (h *ImpersonationHandler) outranks(actorID, targetID string) (bool, error) {
+	held, err := h.authRepo.GetUserPermissionNames(actorID)
+	if err != nil {
+		return false, err
+	}
+
+	holds := make(map[string]bool, len(held))
+	for _, name := range held {
+		holds[name] = true
+	}
+
+	needed, err := h.authRepo.GetUserPermissionNames(targetID)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range needed {
+		if !holds[name] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/mfa"
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// secondFactors lists the methods a user can complete a password login with, none means no second step
+func (h *Handler) secondFactors(u *user.User) ([]string, error) {
+	return h.mfaRepo.SecondFactors(u.ID, h.passkeys)
+}
+
+// requireMFA answers the password step of a user with a second factor, offering the given methods
+// The mfa_pending token only proves the password was right and cannot be used as an access token
+func (h *Handler) requireMFA(c *gin.Context, u *user.User, methods []string) {
+	lifetime := time.Duration(h.cfg.MFAPendingMinutes) * time.Minute
+	mfaToken, err := jwt.IssueActionToken(jwt.PurposeMFAPending, u.ID, passwordFingerprint(u), lifetime)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "MFA required",
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+		"methods":      methods,
+		"expires_in":   int(lifetime.Seconds()),
+	})
+}
+
+// VerifyMFA completes a login by exchanging the mfa_pending token and a second factor for the real tokens
+func (h *Handler) VerifyMFA(c *gin.Context) {
+	var request MFAVerifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid MFA request: " + err.Error(),
+		})
+		return
+	}
+
+	u, ok := h.pendingMFAUser(c, request.MFAToken)
+	if !ok {
+		return
+	}
+
+	// Wrong codes count as failed logins, otherwise a known password would allow guessing codes without limit
+	attempt, ok := h.checkLoginThrottle(c, u.Username)
+	if !ok {
+		return
+	}
+
+	method, err := h.mfaRepo.Authenticate(u.ID, request.Code)
+	if err != nil {
+		if err == mfa.ErrInvalidCode || err == mfa.ErrNotEnrolled {
+			if h.auditLogger != nil {
+				h.auditLogger.LogFromGin(c, "MFA_FAILED", "user", u.ID, nil)
+			}
+			h.recordLoginFailure(c, u.Username, "invalid_mfa_code", attempt)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid code",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify code",
+		})
+		return
+	}
+
+	h.releaseLoginAttempt(c, u.Username)
+	h.completeLogin(c, u, map[string]interface{}{
+		"mfa_method": method,
+	})
+}
+
+// pendingMFAUser returns the user an mfa_pending token was issued to
+// The token stops working once the password changes or the account is deactivated
+func (h *Handler) pendingMFAUser(c *gin.Context, mfaToken string) (*user.User, bool) {
+	claims, err := jwt.ValidateActionToken(mfaToken, jwt.PurposeMFAPending)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired MFA token",
+		})
+		return nil, false
+	}
+
+	u, err := h.userRepo.GetByID(claims.Subject)
+	if err != nil || !u.Active || passwordFingerprint(u) != claims.Binding {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired MFA token",
+		})
+		return nil, false
+	}
+
+	return u, true
+}
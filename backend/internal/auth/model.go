@@ -1,13 +1,18 @@
 package auth
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// System roles are created by the schema and cannot be renamed or deleted
+// A role inherits the permissions of its parent and, through it, of all the parent's ancestors
 type Role struct {
 	ID string `json:"id"`
 	Name string `json:"name"`
 	Description string `json:"description"`
+	System bool `json:"system"`
+	ParentID *string `json:"parent_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -32,15 +37,79 @@ type UserRole struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Permissions are granted to the role itself, InheritedPermissions come from its ancestors
 type RoleResponse struct {
 	ID string `json:"id"`
 	Name string `json:"name"`
 	Description string `json:"description"`
+	System bool `json:"system"`
+	ParentID *string `json:"parent_id"`
 	Permissions []string `json:"permissions"`
+	InheritedPermissions []string `json:"inherited_permissions"`
 }
 
 type PermissionResponse struct {
 	ID string `json:"id"`
 	Name string `json:"name"`
 	Description string `json:"description"`
-}
\ No newline at end of file
+}
+// For input validation when creating a role
+type RoleCreation struct {
+	Name string `json:"name" binding:"required,min=2,max=50"`
+	Description string `json:"description" binding:"max=500"`
+	ParentID string `json:"parent_id"`
+}
+
+// For input validation when updating a role
+// Fields left out of the request are not changed, an empty parent_id removes the parent
+type RoleUpdate struct {
+	Name *string `json:"name" binding:"omitempty,min=2,max=50"`
+	Description *string `json:"description" binding:"omitempty,max=500"`
+	ParentID *string `json:"parent_id"`
+}
+
+// For input validation when an admin starts impersonating a user
+type ImpersonationRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// For input validation when verifying an email address
+type EmailVerificationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// For input validation when asking for a new verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// For input validation when asking for a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// For input validation when resetting a password with the emailed token
+type ResetPasswordRequest struct {
+	Token string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// For input validation on the second login step
+// Code may be a TOTP code or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code string `json:"code" binding:"required"`
+}
+
+// For input validation when starting a passkey second factor
+type MFAWebAuthnBeginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// For input validation when finishing a passkey second factor
+// Credential is the PublicKeyCredential returned by navigator.credentials.get()
+type MFAWebAuthnFinishRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	SessionID string `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
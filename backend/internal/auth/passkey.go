@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/bharabhi01/authservice/internal/mfa"
+	"github.com/bharabhi01/authservice/internal/passkey"
+	"github.com/gin-gonic/gin"
+)
+
+// BeginPasskeyLogin starts a login with a passkey instead of a username and password
+func (h *Handler) BeginPasskeyLogin(c *gin.Context) {
+	options, sessionID, err := h.passkeys.BeginDiscoverableLogin()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start passkey login",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// FinishPasskeyLogin verifies the passkey assertion and logs the user in
+// A passkey verifies the user on the authenticator, so no further MFA step is asked for
+func (h *Handler) FinishPasskeyLogin(c *gin.Context) {
+	var request passkey.AssertionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid passkey login: " + err.Error(),
+		})
+		return
+	}
+
+	u, credential, err := h.passkeys.FinishDiscoverableLogin(request.SessionID, request.Credential)
+	if err != nil {
+		h.passkeyError(c, "", err)
+		return
+	}
+
+	if !u.Active {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Account is not active",
+		})
+		return
+	}
+
+	if h.cfg.RequireEmailVerification && !u.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Email address is not verified",
+		})
+		return
+	}
+
+	h.completeLogin(c, u, map[string]interface{}{
+		"method":     "passkey",
+		"passkey_id": credential.ID,
+	})
+}
+
+// BeginMFAWebAuthn starts using a passkey as the second factor after the password step
+func (h *Handler) BeginMFAWebAuthn(c *gin.Context) {
+	var request MFAWebAuthnBeginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid MFA request: " + err.Error(),
+		})
+		return
+	}
+
+	u, ok := h.pendingMFAUser(c, request.MFAToken)
+	if !ok {
+		return
+	}
+
+	options, sessionID, err := h.passkeys.BeginLogin(u)
+	if err != nil {
+		if err == passkey.ErrNoCredentials {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "No passkey registered",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start passkey verification",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// FinishMFAWebAuthn completes a login by exchanging the mfa_pending token and a passkey assertion for the real tokens
+func (h *Handler) FinishMFAWebAuthn(c *gin.Context) {
+	var request MFAWebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid MFA request: " + err.Error(),
+		})
+		return
+	}
+
+	u, ok := h.pendingMFAUser(c, request.MFAToken)
+	if !ok {
+		return
+	}
+
+	credential, err := h.passkeys.FinishLogin(u, request.SessionID, request.Credential)
+	if err != nil {
+		if err == passkey.ErrInvalidCredential || err == passkey.ErrCloneWarning {
+			if h.auditLogger != nil {
+				h.auditLogger.LogFromGin(c, "MFA_FAILED", "user", u.ID, nil)
+			}
+		}
+		h.passkeyError(c, u.ID, err)
+		return
+	}
+
+	h.completeLogin(c, u, map[string]interface{}{
+		"mfa_method": mfa.MethodWebAuthn,
+		"passkey_id": credential.ID,
+	})
+}
+
+// passkeyError answers a failed passkey assertion
+// Possibly cloned authenticators are audited so admins can remove the credential
+func (h *Handler) passkeyError(c *gin.Context, userID string, err error) {
+	switch err {
+	case passkey.ErrSessionNotFound:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Passkey challenge expired, please start again",
+		})
+	case passkey.ErrInvalidCredential:
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Passkey could not be verified",
+		})
+	case passkey.ErrCloneWarning:
+		if h.auditLogger != nil {
+			h.auditLogger.LogFromGin(c, "PASSKEY_CLONE_WARNING", "user", userID, nil)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Passkey could not be verified",
+		})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to verify passkey",
+		})
+	}
+}
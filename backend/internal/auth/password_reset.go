@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/bharabhi01/authservice/pkg/mailer"
+	"github.com/gin-gonic/gin"
+)
+
+// passwordFingerprint identifies the current password of a user without revealing its hash
+// Reset tokens are bound to it, so they can be used only once and die with any password change
+func passwordFingerprint(u *user.User) string {
+	sum := sha256.Sum256([]byte(u.PasswordHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendPasswordResetEmail mails a user a link to choose a new password
+func (h *Handler) sendPasswordResetEmail(ctx context.Context, u *user.User) error {
+	lifetime := time.Duration(h.cfg.PasswordResetMinutes) * time.Minute
+	resetToken, err := jwt.IssueActionToken(jwt.PurposePasswordReset, u.ID, passwordFingerprint(u), lifetime)
+	if err != nil {
+		return err
+	}
+
+	link := h.cfg.AppURL + "/reset-password?token=" + url.QueryEscape(resetToken)
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open the link below:\n\n%s\n\nThe link expires in %d minutes. If you did not ask for this, you can ignore this email.\n",
+			u.Username, link, h.cfg.PasswordResetMinutes,
+		),
+	})
+}
+
+// ForgotPassword emails a password reset link, only ever to verified addresses
+// An address nobody proved to own would otherwise be enough to take over the account
+// The response is the same whether or not the account exists, and the email is sent in the background
+// so that response times do not tell either
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var request ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid password reset request: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByEmail(request.Email)
+	if err == nil && u.Active && u.EmailVerified {
+		go func() {
+			if err := h.sendPasswordResetEmail(context.Background(), u); err != nil {
+				log.Printf("Failed to send password reset email to user %s: %v", u.ID, err)
+			}
+		}()
+
+		if h.auditLogger != nil {
+			h.auditLogger.LogFromGin(c, "PASSWORD_RESET_REQUESTED", "user", u.ID, nil)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an account with that email exists, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using the token from the reset email
+// Every token, session, API key and passkey of the user is revoked, since the old password may have been compromised
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var request ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid password reset request: " + err.Error(),
+		})
+		return
+	}
+
+	claims, err := jwt.ValidateActionToken(request.Token, jwt.PurposePasswordReset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid or expired reset token",
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(claims.Subject)
+	if err != nil || !u.Active || passwordFingerprint(u) != claims.Binding {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid or expired reset token",
+		})
+		return
+	}
+
+	if !h.checkPasswordPolicy(c, request.Password, u) {
+		return
+	}
+
+	if err := h.userRepo.UpdatePassword(u.ID, request.Password); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to reset password",
+		})
+		return
+	}
+
+	if err := h.revokeAllTokens(c, u.ID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke tokens",
+		})
+		return
+	}
+
+	// Passkeys may have been added by whoever knew the old password, the user registers theirs again
+	passkeysRemoved, err := h.passkeys.RemoveCredentials(u.ID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to remove passkeys",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"jti":              claims.Id,
+			"passkeys_removed": passkeysRemoved,
+		}
+		h.auditLogger.LogFromGin(c, "PASSWORD_RESET", "user", u.ID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password reset successfully",
+	})
+}
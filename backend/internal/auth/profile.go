@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/mailer"
+	"github.com/gin-gonic/gin"
+)
+
+// ChangePassword changes the current user's password after checking the current one
+// Wrong current passwords count as failed logins
+// Every other session of the user is ended, the one making the request stays logged in
+func (h *Handler) ChangePassword(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	var request user.PasswordChange
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid password change: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if !h.verifier.Password(c, u, request.CurrentPassword, "password_change") {
+		return
+	}
+
+	if !h.checkPasswordPolicy(c, request.NewPassword, u) {
+		return
+	}
+
+	if err := h.userRepo.UpdatePassword(u.ID, request.NewPassword); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to change password",
+		})
+		return
+	}
+
+	ended, err := h.endOtherSessions(u.ID, c.GetString("sessionID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to end other sessions",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"before": map[string]interface{}{
+				"updated_at": u.UpdatedAt,
+			},
+			"after": map[string]interface{}{
+				"updated_at": time.Now(),
+			},
+			"sessions_ended": ended,
+		}
+		h.auditLogger.LogFromGin(c, "PASSWORD_CHANGED", "user", u.ID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed successfully",
+	})
+}
+
+// UpdateProfile updates the current user's name and email address
+// Changing the email address needs the current password, marks it unverified and sends a new verification email
+// The old address is told about the change, so a hijacked session cannot move the account away silently
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	var request user.ProfileUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid profile data: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
+
+	if request.FirstName != nil && *request.FirstName != u.FirstName {
+		before["first_name"], after["first_name"] = u.FirstName, *request.FirstName
+		u.FirstName = *request.FirstName
+	}
+	if request.LastName != nil && *request.LastName != u.LastName {
+		before["last_name"], after["last_name"] = u.LastName, *request.LastName
+		u.LastName = *request.LastName
+	}
+
+	emailChanged := request.Email != nil && *request.Email != u.Email
+	if emailChanged {
+		if existing, err := h.userRepo.GetByEmail(*request.Email); err == nil && existing.ID != u.ID {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Email is already in use",
+			})
+			return
+		}
+
+		if !h.verifier.Password(c, u, request.CurrentPassword, "email_change") {
+			return
+		}
+
+		before["email"], after["email"] = u.Email, *request.Email
+		u.Email = *request.Email
+		u.EmailVerified = false
+		u.EmailVerifiedAt = nil
+	}
+
+	if len(after) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Nothing to update",
+			"user":    u.ToResponse(),
+		})
+		return
+	}
+
+	if err := h.userRepo.Update(u); err != nil {
+		if err == user.ErrEmailTaken {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Email is already in use",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update profile",
+		})
+		return
+	}
+
+	if emailChanged {
+		if err := h.sendVerificationEmail(c.Request.Context(), u); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", u.ID, err)
+		}
+
+		oldEmail, newEmail := before["email"].(string), u.Email
+		go func() {
+			if err := h.sendEmailChangedEmail(context.Background(), u.Username, oldEmail, newEmail); err != nil {
+				log.Printf("Failed to notify user %s of the email change: %v", u.ID, err)
+			}
+		}()
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"before": before,
+			"after":  after,
+		}
+		h.auditLogger.LogFromGin(c, "PROFILE_UPDATED", "user", u.ID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Profile updated successfully",
+		"user":    u.ToResponse(),
+	})
+}
+
+// sendEmailChangedEmail tells the previous address of an account that the email address was changed
+func (h *Handler) sendEmailChangedEmail(ctx context.Context, username, oldEmail, newEmail string) error {
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThe email address of your account was changed to %s. Emails about your account will go there from now on.\n\nIf you did not make this change, contact support right away.\n",
+			username, newEmail,
+		),
+	})
+}
+
+// endOtherSessions ends every session of a user except keepID, and returns how many were ended
+func (h *Handler) endOtherSessions(userID, keepID string) (int, error) {
+	sessions, err := h.sessionRepo.GetByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	ended := 0
+	for _, s := range sessions {
+		if s.ID == keepID {
+			continue
+		}
+		if err := h.endSession(s.ID); err != nil {
+			return ended, err
+		}
+		ended++
+	}
+
+	return ended, nil
+}
@@ -2,11 +2,47 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/lib/pq"
 )
 
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists = errors.New("a role with this name already exists")
+	ErrSystemRole = errors.New("system roles cannot be renamed, deleted or lose permissions")
+	ErrPermissionNotFound = errors.New("permission not found")
+	ErrRoleCycle = errors.New("a role cannot inherit from itself or from one of the roles inheriting from it")
+)
+
+// validID reports whether id can be a user, role or permission ID at all, so malformed IDs are not sent to the database
+func validID(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 32)
+	return err == nil
+}
+
+// heldRoles is a recursive CTE listing the roles the user $1 holds, together with every role they inherit from
+// UNION stops the recursion even if the hierarchy in the database ever contains a cycle
+const heldRoles = `
+	WITH RECURSIVE held_roles(role_id) AS (
+		SELECT role_id FROM user_roles WHERE user_id = $1
+		UNION
+		SELECT r.parent_id FROM roles r JOIN held_roles h ON r.id = h.role_id WHERE r.parent_id IS NOT NULL
+	)
+`
+
+// ancestorRoles is a recursive CTE listing the roles the role $1 inherits from
+const ancestorRoles = `
+	WITH RECURSIVE ancestor_roles(role_id) AS (
+		SELECT parent_id FROM roles WHERE id = $1 AND parent_id IS NOT NULL
+		UNION
+		SELECT r.parent_id FROM roles r JOIN ancestor_roles a ON r.id = a.role_id WHERE r.parent_id IS NOT NULL
+	)
+`
+
 type Repository struct {
 	db *sql.DB
 }
@@ -19,7 +55,7 @@ func NewRepository() *Repository {
 
 func (r *Repository) GetRoles() ([]Role, error) {
 	query := `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, name, description, is_system, parent_id, created_at, updated_at
 		FROM roles
 		ORDER BY name
 	`
@@ -39,6 +75,8 @@ func (r *Repository) GetRoles() ([]Role, error) {
 			&role.ID,
 			&role.Name,
 			&role.Description,
+			&role.System,
+			&role.ParentID,
 			&role.CreatedAt,
 			&role.UpdatedAt,
 		); err != nil {
@@ -56,8 +94,12 @@ func (r *Repository) GetRoles() ([]Role, error) {
 }
 
 func (r * Repository) GetRoleByID(id string) (*Role, error) {
+	if !validID(id) {
+		return nil, nil
+	}
+
 	query := `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, name, description, is_system, parent_id, created_at, updated_at
 		FROM roles
 		WHERE id = $1
 	`
@@ -67,6 +109,8 @@ func (r * Repository) GetRoleByID(id string) (*Role, error) {
 		&role.ID,
 		&role.Name,
 		&role.Description,
+		&role.System,
+		&role.ParentID,
 		&role.CreatedAt,
 		&role.UpdatedAt,
 	)
@@ -84,7 +128,7 @@ func (r * Repository) GetRoleByID(id string) (*Role, error) {
 
 func (r *Repository) GetRoleByName(name string) (*Role, error) {
 	query := `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, name, description, is_system, parent_id, created_at, updated_at
 		FROM roles
 		WHERE name = $1	
 	`
@@ -94,6 +138,8 @@ func (r *Repository) GetRoleByName(name string) (*Role, error) {
 		&role.ID,
 		&role.Name,
 		&role.Description,
+		&role.System,
+		&role.ParentID,
 		&role.CreatedAt,
 		&role.UpdatedAt,
 	)
@@ -181,10 +227,55 @@ func (r *Repository) GetRolePermissions(roleID string) ([]Permission, error) {
 	return permissions, nil
 }	
 
+// GetInheritedPermissions returns the permissions a role inherits from its ancestors
+// Permissions granted to the role itself are left out
+func (r *Repository) GetInheritedPermissions(roleID string) ([]Permission, error) {
+	query := ancestorRoles + `
+		SELECT DISTINCT p.id, p.name, p.description, p.created_at, p.updated_at
+		FROM permissions p
+		JOIN role_permissions rp ON p.id = rp.permission_id
+		JOIN ancestor_roles a ON rp.role_id = a.role_id
+		WHERE p.id NOT IN (SELECT permission_id FROM role_permissions WHERE role_id = $1)
+		ORDER BY p.name
+	`
+
+	rows, err := r.db.Query(query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []Permission
+	for rows.Next() {
+		var permission Permission
+		if err := rows.Scan(
+			&permission.ID,
+			&permission.Name,
+			&permission.Description,
+			&permission.CreatedAt,
+			&permission.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
 func (r *Repository) GetUserRoles(userID string) ([]Role, error) {
+	// No user has a malformed ID, so no roles either
+	if !validID(userID) {
+		return nil, nil
+	}
+
 	// SQL query to get roles for a user
 	query := `
-		SELECT r.id, r.name, r.description, r.created_at, r.updated_at
+		SELECT r.id, r.name, r.description, r.is_system, r.parent_id, r.created_at, r.updated_at
 		FROM roles r
 		JOIN user_roles ur ON r.id = ur.role_id
 		WHERE ur.user_id = $1
@@ -206,6 +297,8 @@ func (r *Repository) GetUserRoles(userID string) ([]Role, error) {
 			&role.ID,
 			&role.Name,
 			&role.Description,
+			&role.System,
+			&role.ParentID,
 			&role.CreatedAt,
 			&role.UpdatedAt,
 		); err != nil {
@@ -223,15 +316,18 @@ func (r *Repository) GetUserRoles(userID string) ([]Role, error) {
 }
 
 // GetUserPermissions retrieves all permissions for a specific user
-// This function is used to determine what permissions a user has through their roles
+// This function is used to determine what permissions a user has through their roles and the roles those inherit from
 func (r *Repository) GetUserPermissions(userID string) ([]Permission, error) {
+	if !validID(userID) {
+		return nil, nil
+	}
+
 	// SQL query to get permissions for a user through their roles
-	query := `
+	query := heldRoles + `
 		SELECT DISTINCT p.id, p.name, p.description, p.created_at, p.updated_at
 		FROM permissions p
 		JOIN role_permissions rp ON p.id = rp.permission_id
-		JOIN user_roles ur ON rp.role_id = ur.role_id
-		WHERE ur.user_id = $1
+		JOIN held_roles h ON rp.role_id = h.role_id
 		ORDER BY p.name
 	`
 
@@ -266,6 +362,22 @@ func (r *Repository) GetUserPermissions(userID string) ([]Permission, error) {
 	return permissions, nil
 }
 
+// GetUserPermissionNames returns the names of the permissions a user holds through their roles
+// This function is used by the permission middleware to authorize requests
+func (r *Repository) GetUserPermissionNames(userID string) ([]string, error) {
+	permissions, err := r.GetUserPermissions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = p.Name
+	}
+
+	return names, nil
+}
+
 // AssignRoleToUser assigns a role to a user
 // This function is used to give a user a new role
 func (r *Repository) AssignRoleToUser(userID, roleID string) error {
@@ -298,14 +410,18 @@ func (r *Repository) RemoveRoleFromUser(userID, roleID string) error {
 // HasPermission checks if a user has a specific permission
 // This function is used for permission-based access control
 func (r *Repository) HasPermission(userID, permissionName string) (bool, error) {
-	// SQL query to check if a user has a permission through any of their roles
-	query := `
+	if !validID(userID) {
+		return false, nil
+	}
+
+	// SQL query to check if a user has a permission through any of their roles, directly or inherited
+	query := heldRoles + `
 		SELECT EXISTS (
 			SELECT 1
 			FROM permissions p
 			JOIN role_permissions rp ON p.id = rp.permission_id
-			JOIN user_roles ur ON rp.role_id = ur.role_id
-			WHERE ur.user_id = $1 AND p.name = $2
+			JOIN held_roles h ON rp.role_id = h.role_id
+			WHERE p.name = $2
 		)
 	`
 
@@ -313,4 +429,198 @@ func (r *Repository) HasPermission(userID, permissionName string) (bool, error)
 	var hasPermission bool
 	err := r.db.QueryRow(query, userID, permissionName).Scan(&hasPermission)
 	return hasPermission, err
-}
\ No newline at end of file
+}
+
+// CreateRole creates a role without permissions of its own
+// A new role has no descendants, so its parent cannot close a cycle
+func (r *Repository) CreateRole(creation *RoleCreation) (*Role, error) {
+	now := time.Now()
+	role := &Role{
+		Name: creation.Name,
+		Description: creation.Description,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if creation.ParentID != "" {
+		role.ParentID = &creation.ParentID
+	}
+
+	query := `
+		INSERT INTO roles (name, description, is_system, parent_id, created_at, updated_at)
+		VALUES ($1, $2, FALSE, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := r.db.QueryRow(query, role.Name, role.Description, role.ParentID, role.CreatedAt, role.UpdatedAt).Scan(&role.ID)
+	if err != nil {
+		return nil, roleConflict(err)
+	}
+
+	return role, nil
+}
+
+// UpdateRole stores the name, description and parent of a role
+// Assignments refer to the role by ID, so a rename carries over to the users holding it
+// It returns ErrRoleCycle when the new parent already inherits from the role
+func (r *Repository) UpdateRole(role *Role, oldName string) error {
+	if role.System && role.Name != oldName {
+		return ErrSystemRole
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Two concurrent parent changes could each pass the cycle check and close a cycle together
+	if _, err := tx.Exec(`LOCK TABLE roles IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	if role.ParentID != nil {
+		query := `
+			WITH RECURSIVE lineage(role_id) AS (
+				SELECT id FROM roles WHERE id = $1
+				UNION
+				SELECT r.parent_id FROM roles r JOIN lineage l ON r.id = l.role_id WHERE r.parent_id IS NOT NULL
+			)
+			SELECT EXISTS (SELECT 1 FROM lineage WHERE role_id = $2)
+		`
+
+		var cycle bool
+		if err := tx.QueryRow(query, *role.ParentID, role.ID).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return ErrRoleCycle
+		}
+	}
+
+	role.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE roles
+		SET name = $2, description = $3, parent_id = $4, updated_at = $5
+		WHERE id = $1
+	`
+
+	result, err := tx.Exec(query, role.ID, role.Name, role.Description, role.ParentID, role.UpdatedAt)
+	if err != nil {
+		return roleConflict(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrRoleNotFound
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRole deletes a role along with its permission and user assignments
+// Users left without any role fall back to the user role,
+// and roles inheriting from it inherit from its parent instead
+func (r *Repository) DeleteRole(role *Role) error {
+	if role.System {
+		return ErrSystemRole
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO user_roles (user_id, role_id, created_at)
+		SELECT ur.user_id, fallback.id, $2
+		FROM user_roles ur
+		JOIN roles fallback ON fallback.name = 'user'
+		WHERE ur.role_id = $1
+		  AND NOT EXISTS (SELECT 1 FROM user_roles other WHERE other.user_id = ur.user_id AND other.role_id <> $1)
+		ON CONFLICT (user_id, role_id) DO NOTHING
+	`
+
+	if _, err := tx.Exec(query, role.ID, time.Now()); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE roles SET parent_id = $2 WHERE parent_id = $1`, role.ID, role.ParentID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM roles WHERE id = $1 AND is_system = FALSE`, role.ID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrRoleNotFound
+	}
+
+	return tx.Commit()
+}
+
+// GetPermissionByID returns a permission, or nil if it does not exist
+func (r *Repository) GetPermissionByID(id string) (*Permission, error) {
+	if !validID(id) {
+		return nil, nil
+	}
+
+	query := `
+		SELECT id, name, description, created_at, updated_at
+		FROM permissions
+		WHERE id = $1
+	`
+
+	var permission Permission
+	err := r.db.QueryRow(query, id).Scan(
+		&permission.ID,
+		&permission.Name,
+		&permission.Description,
+		&permission.CreatedAt,
+		&permission.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &permission, nil
+}
+
+// AddPermissionToRole grants a permission to a role, granting it twice is not an error
+func (r *Repository) AddPermissionToRole(roleID, permissionID string) error {
+	query := `
+		INSERT INTO role_permissions (role_id, permission_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (role_id, permission_id) DO NOTHING
+	`
+
+	_, err := r.db.Exec(query, roleID, permissionID, time.Now())
+	return err
+}
+
+// RemovePermissionFromRole takes a permission away from a role
+// System roles keep their permissions, otherwise admin could be locked out of managing roles
+func (r *Repository) RemovePermissionFromRole(role *Role, permissionID string) error {
+	if role.System {
+		return ErrSystemRole
+	}
+
+	_, err := r.db.Exec(`DELETE FROM role_permissions WHERE role_id = $1 AND permission_id = $2`, role.ID, permissionID)
+	return err
+}
+
+// roleConflict maps a duplicate role name to ErrRoleExists
+func roleConflict(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrRoleExists
+	}
+	return err
+}
@@ -3,75 +3,487 @@ package auth
 import (
 	"net/http"
 
+	"github.com/bharabhi01/authservice/pkg/audit"
 	"github.com/gin-gonic/gin"
 )
 
 // RoleHandler handles role and permission management requests
 // It contains dependencies needed for role operations
 type RoleHandler struct {
-	authRepo *Repository
+	authRepo    *Repository
+	assignments AssignmentRules
+	auditLogger *audit.Logger
 }
 
 // NewRoleHandler creates a new role handler
 // This function initializes the handler with required dependencies
-func NewRoleHandler(authRepo *Repository) *RoleHandler {
+func NewRoleHandler(authRepo *Repository, assignments AssignmentRules, auditLogger *audit.Logger) *RoleHandler {
 	return &RoleHandler{
-		authRepo: authRepo,
+		authRepo:    authRepo,
+		assignments: assignments,
+		auditLogger: auditLogger,
 	}
 }
 
 // GetRoles returns all roles
-// This endpoint lists all available roles
+// This endpoint lists all available roles with the permissions they hold directly and through inheritance
 func (h *RoleHandler) GetRoles(c *gin.Context) {
 	// Get all roles from the repository
 	roles, err := h.authRepo.GetRoles()
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get roles: " + err.Error(),
+			"error": "Failed to get roles",
 		})
 		return
 	}
 
 	// Convert to response format
 	var response []RoleResponse
-	for _, role := range roles {
-		// Get permissions for this role
-		permissions, err := h.authRepo.GetRolePermissions(role.ID)
+	for i := range roles {
+		// Get direct and inherited permissions for this role
+		roleResponse, err := h.describeRole(&roles[i])
 		if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to get role permissions: " + err.Error(),
+				"error": "Failed to get role permissions",
 			})
 			return
 		}
 
-		// Extract permission names
-		permissionNames := make([]string, len(permissions))
-		for i, p := range permissions {
-			permissionNames[i] = p.Name
+		// Add to response
+		response = append(response, roleResponse)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": response,
+	})
+}
+
+// CreateRole creates a new role
+// The role starts without permissions of its own, they are granted through AddPermissionToRole
+// or inherited from the parent role
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var request RoleCreation
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid role data: " + err.Error(),
+		})
+		return
+	}
+
+	if request.ParentID != "" && !h.checkParent(c, "", request.Name, request.ParentID) {
+		return
+	}
+
+	role, err := h.authRepo.CreateRole(&request)
+	if err != nil {
+		if err == ErrRoleExists {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
 		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create role",
+		})
+		return
+	}
 
-		// Add to response
-		response = append(response, RoleResponse{
-			ID:          role.ID,
-			Name:        role.Name,
-			Description: role.Description,
-			Permissions: permissionNames,
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"name":        role.Name,
+			"description": role.Description,
+			"parent_id":   role.ParentID,
+		}
+		h.auditLogger.LogFromGin(c, "ROLE_CREATED", "role", role.ID, details)
+	}
+
+	response, err := h.describeRole(role)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get role permissions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Role created successfully",
+		"role":    response,
+	})
+}
+
+// UpdateRole changes the name, description or parent of a role
+// System roles keep their name, only their description and parent can change
+// So do roles named in the assignment rules, and no role can be renamed to a name the rules use
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	var request RoleUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid role data: " + err.Error(),
 		})
+		return
+	}
+
+	role, ok := h.loadRole(c)
+	if !ok {
+		return
+	}
+
+	before := map[string]interface{}{
+		"name":        role.Name,
+		"description": role.Description,
+		"parent_id":   role.ParentID,
+	}
+	oldName := role.Name
+
+	if request.Name != nil && *request.Name != role.Name {
+		// ROLE_ASSIGNMENT_RULES name roles, a rename would silently grant or drop assignment rights
+		if h.assignments.Mentions(role.Name) || h.assignments.Mentions(*request.Name) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Roles named in ROLE_ASSIGNMENT_RULES cannot be renamed, change the rules first",
+			})
+			return
+		}
+		role.Name = *request.Name
+	}
+	if request.Description != nil {
+		role.Description = *request.Description
+	}
+	if request.ParentID != nil {
+		unchanged := role.ParentID != nil && *role.ParentID == *request.ParentID
+		if *request.ParentID == "" {
+			role.ParentID = nil
+		} else if !unchanged {
+			if !h.checkParent(c, role.ID, role.Name, *request.ParentID) {
+				return
+			}
+			role.ParentID = request.ParentID
+		}
+	}
+
+	if err := h.authRepo.UpdateRole(role, oldName); err != nil {
+		switch err {
+		case ErrSystemRole:
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+		case ErrRoleExists:
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		case ErrRoleCycle:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		case ErrRoleNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Role not found",
+			})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update role",
+			})
+		}
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"before": before,
+			"after": map[string]interface{}{
+				"name":        role.Name,
+				"description": role.Description,
+				"parent_id":   role.ParentID,
+			},
+		}
+		h.auditLogger.LogFromGin(c, "ROLE_UPDATED", "role", role.ID, details)
+	}
+
+	h.respondWithRole(c, role)
+}
+
+// DeleteRole deletes a role and removes it from every user
+// System roles like admin cannot be deleted
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	role, ok := h.loadRole(c)
+	if !ok {
+		return
+	}
+
+	if err := h.authRepo.DeleteRole(role); err != nil {
+		switch err {
+		case ErrSystemRole:
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+		case ErrRoleNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Role not found",
+			})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to delete role",
+			})
+		}
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"name": role.Name,
+		}
+		h.auditLogger.LogFromGin(c, "ROLE_DELETED", "role", role.ID, details)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"roles": response,
+		"message": "Role deleted successfully",
+	})
+}
+
+// AddPermissionToRole grants a permission to a role
+// This endpoint manages role_permissions, granting a permission twice has no effect
+func (h *RoleHandler) AddPermissionToRole(c *gin.Context) {
+	h.changeRolePermission(c, true)
+}
+
+// RemovePermissionFromRole takes a permission away from a role
+// This endpoint manages role_permissions, removing a permission the role lacks has no effect
+// Permissions of system roles like admin cannot be removed
+func (h *RoleHandler) RemovePermissionFromRole(c *gin.Context) {
+	h.changeRolePermission(c, false)
+}
+
+func (h *RoleHandler) changeRolePermission(c *gin.Context, grant bool) {
+	role, ok := h.loadRole(c)
+	if !ok {
+		return
+	}
+
+	permission, err := h.authRepo.GetPermissionByID(c.Param("permId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get permission",
+		})
+		return
+	}
+	if permission == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Permission not found",
+		})
+		return
+	}
+
+	// Granting what one does not hold would be a way around the role assignment rules
+	if grant {
+		held, err := h.authRepo.HasPermission(c.GetString("userID"), permission.Name)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check permissions",
+			})
+			return
+		}
+		if !held {
+			if h.auditLogger != nil {
+				details := map[string]interface{}{
+					"role":       role.Name,
+					"permission": permission.Name,
+					"reason":     "permission_not_held",
+				}
+				h.auditLogger.LogFromGin(c, "ROLE_PERMISSION_DENIED", "role", role.ID, details)
+			}
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "You cannot grant a permission you do not hold",
+			})
+			return
+		}
+	}
+
+	action := "ROLE_PERMISSION_ADDED"
+	if grant {
+		err = h.authRepo.AddPermissionToRole(role.ID, permission.ID)
+	} else {
+		action = "ROLE_PERMISSION_REMOVED"
+		err = h.authRepo.RemovePermissionFromRole(role, permission.ID)
+	}
+	if err == ErrSystemRole {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update role permissions",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"role":       role.Name,
+			"permission": permission.Name,
+		}
+		h.auditLogger.LogFromGin(c, action, "role", role.ID, details)
+	}
+
+	h.respondWithRole(c, role)
+}
+
+// loadRole gets the role named by the id URL parameter
+// It answers the request itself when the role cannot be loaded
+func (h *RoleHandler) loadRole(c *gin.Context) (*Role, bool) {
+	role, err := h.authRepo.GetRoleByID(c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get role",
+		})
+		return nil, false
+	}
+	if role == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Role not found",
+		})
+		return nil, false
+	}
+
+	return role, true
+}
+
+// respondWithRole answers with a role and its current permissions
+func (h *RoleHandler) respondWithRole(c *gin.Context, role *Role) {
+	response, err := h.describeRole(role)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get role permissions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"role": response,
 	})
 }
 
+// describeRole builds the response for a role with its direct and inherited permissions
+func (h *RoleHandler) describeRole(role *Role) (RoleResponse, error) {
+	permissions, err := h.authRepo.GetRolePermissions(role.ID)
+	if err != nil {
+		return RoleResponse{}, err
+	}
+
+	inherited, err := h.authRepo.GetInheritedPermissions(role.ID)
+	if err != nil {
+		return RoleResponse{}, err
+	}
+
+	return RoleResponse{
+		ID:                   role.ID,
+		Name:                 role.Name,
+		Description:          role.Description,
+		System:               role.System,
+		ParentID:             role.ParentID,
+		Permissions:          permissionNames(permissions),
+		InheritedPermissions: permissionNames(inherited),
+	}, nil
+}
+
+// effectivePermissions returns the names of every permission a role grants, directly or inherited
+func (h *RoleHandler) effectivePermissions(role *Role) ([]string, error) {
+	permissions, err := h.authRepo.GetRolePermissions(role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	inherited, err := h.authRepo.GetInheritedPermissions(role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissionNames(append(permissions, inherited...)), nil
+}
+
+// checkParent checks that the role named by parentID exists and may become the parent of a role
+// The caller must already hold everything the parent grants, otherwise they could give
+// a role of their own more permissions through inheritance; such attempts are answered with 403 and audited
+func (h *RoleHandler) checkParent(c *gin.Context, roleID, roleName, parentID string) bool {
+	parent, err := h.authRepo.GetRoleByID(parentID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get parent role",
+		})
+		return false
+	}
+	if parent == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Parent role not found",
+		})
+		return false
+	}
+
+	inherited, err := h.effectivePermissions(parent)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get role permissions",
+		})
+		return false
+	}
+
+	missing, err := h.missingPermissions(c.GetString("userID"), inherited)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check permissions",
+		})
+		return false
+	}
+
+	if len(missing) > 0 {
+		if h.auditLogger != nil {
+			details := map[string]interface{}{
+				"role":   roleName,
+				"parent": parent.Name,
+				"reason": "permission_not_held",
+			}
+			h.auditLogger.LogFromGin(c, "ROLE_PARENT_DENIED", "role", roleID, details)
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You cannot make a role inherit permissions you do not hold",
+		})
+		return false
+	}
+
+	return true
+}
+
+func permissionNames(permissions []Permission) []string {
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = p.Name
+	}
+	return names
+}
+
 // GetPermissions returns all permissions
 // This endpoint lists all available permissions
 func (h *RoleHandler) GetPermissions(c *gin.Context) {
 	// Get all permissions from the repository
 	permissions, err := h.authRepo.GetPermissions()
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get permissions: " + err.Error(),
+			"error": "Failed to get permissions",
 		})
 		return
 	}
@@ -96,9 +508,9 @@ func (h *RoleHandler) GetPermissions(c *gin.Context) {
 func (h *RoleHandler) GetUserRoles(c *gin.Context) {
 	// Get user ID from URL parameter
 	userID := c.Param("id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "User ID is required",
+	if !validID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
 		})
 		return
 	}
@@ -106,37 +518,28 @@ func (h *RoleHandler) GetUserRoles(c *gin.Context) {
 	// Get user roles from the repository
 	roles, err := h.authRepo.GetUserRoles(userID)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get user roles: " + err.Error(),
+			"error": "Failed to get user roles",
 		})
 		return
 	}
 
 	// Convert to response format
 	var response []RoleResponse
-	for _, role := range roles {
-		// Get permissions for this role
-		permissions, err := h.authRepo.GetRolePermissions(role.ID)
+	for i := range roles {
+		// Get direct and inherited permissions for this role
+		roleResponse, err := h.describeRole(&roles[i])
 		if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to get role permissions: " + err.Error(),
+				"error": "Failed to get role permissions",
 			})
 			return
 		}
 
-		// Extract permission names
-		permissionNames := make([]string, len(permissions))
-		for i, p := range permissions {
-			permissionNames[i] = p.Name
-		}
-
 		// Add to response
-		response = append(response, RoleResponse{
-			ID:          role.ID,
-			Name:        role.Name,
-			Description: role.Description,
-			Permissions: permissionNames,
-		})
+		response = append(response, roleResponse)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -146,16 +549,9 @@ func (h *RoleHandler) GetUserRoles(c *gin.Context) {
 
 // AssignRoleToUser assigns a role to a user
 // This endpoint gives a user a new role
+// Callers can only assign roles the assignment rules allow for their own roles,
+// and cannot give themselves a role carrying permissions they do not hold
 func (h *RoleHandler) AssignRoleToUser(c *gin.Context) {
-	// Get user ID from URL parameter
-	userID := c.Param("id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "User ID is required",
-		})
-		return
-	}
-
 	// Parse request body
 	var request struct {
 		RoleID string `json:"role_id" binding:"required"`
@@ -167,27 +563,19 @@ func (h *RoleHandler) AssignRoleToUser(c *gin.Context) {
 		return
 	}
 
-	// Assign role to user
-	err := h.authRepo.AssignRoleToUser(userID, request.RoleID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to assign role: " + err.Error(),
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Role assigned successfully",
-	})
+	h.changeUserRole(c, request.RoleID, true)
 }
 
 // RemoveRoleFromUser removes a role from a user
 // This endpoint revokes a role from a user
+// Callers can only remove roles the assignment rules allow them to assign
 func (h *RoleHandler) RemoveRoleFromUser(c *gin.Context) {
-	// Get user ID and role ID from URL parameters
+	h.changeUserRole(c, c.Param("roleId"), false)
+}
+
+// changeUserRole assigns a role to or removes it from the user named by the id URL parameter
+func (h *RoleHandler) changeUserRole(c *gin.Context, roleID string, assign bool) {
 	userID := c.Param("id")
-	roleID := c.Param("roleId")
-	
 	if userID == "" || roleID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "User ID and Role ID are required",
@@ -195,17 +583,152 @@ func (h *RoleHandler) RemoveRoleFromUser(c *gin.Context) {
 		return
 	}
 
-	// Remove role from user
-	err := h.authRepo.RemoveRoleFromUser(userID, roleID)
+	if !validID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	role, err := h.authRepo.GetRoleByID(roleID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get role",
+		})
+		return
+	}
+	if role == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Role not found",
+		})
+		return
+	}
+
+	if !h.authorizeAssignment(c, userID, role, assign) {
+		return
+	}
+
+	action := "ROLE_ASSIGNED"
+	if assign {
+		err = h.authRepo.AssignRoleToUser(userID, role.ID)
+	} else {
+		action = "ROLE_UNASSIGNED"
+		err = h.authRepo.RemoveRoleFromUser(userID, role.ID)
+	}
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to remove role: " + err.Error(),
+			"error": "Failed to update user roles",
 		})
 		return
 	}
 
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"role": role.Name,
+		}
+		h.auditLogger.LogFromGin(c, action, "user", userID, details)
+	}
+
+	message := "Role assigned successfully"
+	if !assign {
+		message = "Role removed successfully"
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Role removed successfully",
+		"message": message,
+	})
+}
+
+// authorizeAssignment checks that the caller may assign role to, or remove it from, the user
+// Denied attempts are answered with 403 and audited
+func (h *RoleHandler) authorizeAssignment(c *gin.Context, userID string, role *Role, assign bool) bool {
+	actorID := c.GetString("userID")
+
+	actorRoles, err := h.authRepo.GetUserRoles(actorID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get your roles",
+		})
+		return false
+	}
+
+	roleNames := make([]string, len(actorRoles))
+	for i, r := range actorRoles {
+		roleNames[i] = r.Name
+	}
+
+	if !h.assignments.CanAssign(roleNames, role.Name) {
+		h.denyAssignment(c, userID, role, assign, "role_not_assignable", "You are not allowed to assign the "+role.Name+" role")
+		return false
+	}
+
+	if assign && actorID == userID {
+		granted, err := h.effectivePermissions(role)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get role permissions",
+			})
+			return false
+		}
+
+		missing, err := h.missingPermissions(actorID, granted)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check permissions",
+			})
+			return false
+		}
+		if len(missing) > 0 {
+			h.denyAssignment(c, userID, role, assign, "self_escalation", "You cannot give yourself a role with permissions you do not hold")
+			return false
+		}
+	}
+
+	return true
+}
+
+// missingPermissions returns those of permissions that the user does not hold
+func (h *RoleHandler) missingPermissions(userID string, permissions []string) ([]string, error) {
+	held, err := h.authRepo.GetUserPermissionNames(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	holds := make(map[string]bool, len(held))
+	for _, name := range held {
+		holds[name] = true
+	}
+
+	var missing []string
+	for _, name := range permissions {
+		if !holds[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing, nil
+}
+
+func (h *RoleHandler) denyAssignment(c *gin.Context, userID string, role *Role, assign bool, reason, message string) {
+	if h.auditLogger != nil {
+		operation := "assign"
+		if !assign {
+			operation = "remove"
+		}
+		details := map[string]interface{}{
+			"role":      role.Name,
+			"operation": operation,
+			"reason":    reason,
+		}
+		h.auditLogger.LogFromGin(c, "ROLE_ASSIGNMENT_DENIED", "user", userID, details)
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": message,
 	})
 }
 
@@ -214,9 +737,9 @@ func (h *RoleHandler) RemoveRoleFromUser(c *gin.Context) {
 func (h *RoleHandler) CheckPermission(c *gin.Context) {
 	// Get user ID from URL parameter
 	userID := c.Param("id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "User ID is required",
+	if !validID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
 		})
 		return
 	}
@@ -233,8 +756,9 @@ func (h *RoleHandler) CheckPermission(c *gin.Context) {
 	// Check if user has the permission
 	hasPermission, err := h.authRepo.HasPermission(userID, permissionName)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to check permission: " + err.Error(),
+			"error": "Failed to check permission",
 		})
 		return
 	}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bharabhi01/authservice/pkg/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+// checkLoginThrottle counts a login attempt for username from this client and answers 429 when it is held back
+// The attempt counts as failed until releaseLoginAttempt takes it back, so concurrent guesses cannot slip past the limit
+// It returns false when the request was answered
+func (h *Handler) checkLoginThrottle(c *gin.Context, username string) (throttle.Decision, bool) {
+	decision, err := h.limiter.Attempt(c.Request.Context(), username, c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check login attempts",
+		})
+		return decision, false
+	}
+
+	if decision.Allowed() {
+		return decision, true
+	}
+
+	// Attempts held back still count, so one of them may be the one that locks the account
+	if decision.LocksAccount && h.auditLogger != nil {
+		h.auditAccountLocked(c, username, h.userIDOf(username))
+	}
+
+	message := "Too many failed login attempts, try again later"
+	if decision.Locked {
+		message = "Account is temporarily locked after too many failed login attempts"
+	}
+
+	c.Header("Retry-After", strconv.Itoa(decision.RetryAfterSeconds()))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": decision.RetryAfterSeconds(),
+	})
+	return decision, false
+}
+
+// releaseLoginAttempt takes back the attempt counted by checkLoginThrottle once the credentials proved right
+func (h *Handler) releaseLoginAttempt(c *gin.Context, username string) {
+	if err := h.limiter.Release(c.Request.Context(), username, c.ClientIP()); err != nil {
+		log.Printf("Failed to release login attempt for %s: %v", username, err)
+	}
+}
+
+// recordLoginFailure audits a failed login for username, which checkLoginThrottle already counted
+// Usernames that do not exist are counted too, so the responses do not tell them apart
+func (h *Handler) recordLoginFailure(c *gin.Context, username, reason string, attempt throttle.Decision) {
+	if h.auditLogger == nil {
+		return
+	}
+
+	userID := h.userIDOf(username)
+
+	details := map[string]interface{}{
+		"username": username,
+		"reason":   reason,
+	}
+	h.auditLogger.LogFromGin(c, "LOGIN_FAILED", "user", userID, details)
+
+	if attempt.LocksAccount {
+		h.auditAccountLocked(c, username, userID)
+	}
+}
+
+// auditAccountLocked records that the attempt just counted locked the account of username
+func (h *Handler) auditAccountLocked(c *gin.Context, username, userID string) {
+	details := map[string]interface{}{
+		"username": username,
+	}
+	h.auditLogger.LogFromGin(c, "ACCOUNT_LOCKED", "user", userID, details)
+}
+
+// userIDOf returns the ID of the user named username, or "" for usernames that do not exist
+func (h *Handler) userIDOf(username string) string {
+	if u, err := h.userRepo.GetByUsername(username); err == nil {
+		return u.ID
+	}
+	return ""
+}
@@ -0,0 +1,359 @@
+package mfa
+
+import (
+	"net/http"
+
+	"github.com/bharabhi01/authservice/internal/reauth"
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/bharabhi01/authservice/pkg/totp"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler handles MFA enrollment for the current user and MFA resets by admins
+type Handler struct {
+	mfaRepo     *Repository
+	userRepo    *user.Repository
+	verifier    *reauth.Verifier
+	auditLogger *audit.Logger
+	cfg         *config.Config
+}
+
+func NewHandler(mfaRepo *Repository, userRepo *user.Repository, verifier *reauth.Verifier, auditLogger *audit.Logger, cfg *config.Config) *Handler {
+	return &Handler{
+		mfaRepo:     mfaRepo,
+		userRepo:    userRepo,
+		verifier:    verifier,
+		auditLogger: auditLogger,
+		cfg:         cfg,
+	}
+}
+
+// GetStatus tells whether the current user has MFA enabled
+func (h *Handler) GetStatus(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	status := StatusResponse{}
+	t, err := h.mfaRepo.Get(userID)
+	if err != nil && err != ErrNotEnrolled {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get MFA status",
+		})
+		return
+	}
+
+	if t != nil && t.IsEnabled() {
+		status.Enabled = true
+		status.EnabledAt = t.EnabledAt
+		if status.RecoveryCodesLeft, err = h.mfaRepo.CountRecoveryCodes(userID); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get MFA status",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP generates a TOTP secret for the current user
+// MFA only becomes active once ConfirmTOTP receives a code generated from it
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var request EnrollRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid enrollment: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if !h.verifier.Password(c, u, request.Password, "mfa_enroll") {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate secret",
+		})
+		return
+	}
+
+	if err := h.mfaRepo.StartEnrollment(userID, secret); err != nil {
+		if err == ErrAlreadyEnabled {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "MFA is already enabled",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start enrollment",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, EnrollmentResponse{
+		Secret: secret,
+		URI:    totp.URI(h.cfg.MFAIssuer, u.Username, secret),
+	})
+}
+
+// ConfirmTOTP enables MFA once the user proves their authenticator works
+// The recovery codes are only returned in this response
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var request ConfirmRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid confirmation: " + err.Error(),
+		})
+		return
+	}
+
+	t, err := h.mfaRepo.Get(userID)
+	if err != nil {
+		if err == ErrNotEnrolled {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Start an enrollment first",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get enrollment",
+		})
+		return
+	}
+
+	if t.IsEnabled() {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "MFA is already enabled",
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if !h.verifier.Password(c, u, request.Password, "mfa_confirm") {
+		return
+	}
+
+	if !h.verifyCode(c, userID, request.Code) {
+		return
+	}
+
+	if err := h.mfaRepo.Enable(userID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to enable MFA",
+		})
+		return
+	}
+
+	codes, err := h.mfaRepo.ReplaceRecoveryCodes(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate recovery codes",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		h.auditLogger.LogFromGin(c, "MFA_ENABLED", "user", userID, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "MFA enabled successfully",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var request CodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	if !h.requireEnabled(c, userID) || !h.verifyCode(c, userID, request.Code) {
+		return
+	}
+
+	codes, err := h.mfaRepo.ReplaceRecoveryCodes(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate recovery codes",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		h.auditLogger.LogFromGin(c, "MFA_RECOVERY_CODES_REGENERATED", "user", userID, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns MFA off for the current user, given their password and a second factor
+func (h *Handler) DisableMFA(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var request DisableRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if !h.requireEnabled(c, userID) {
+		return
+	}
+
+	if !h.verifier.Password(c, u, request.Password, "mfa_disable") {
+		return
+	}
+
+	if _, err := h.mfaRepo.Authenticate(userID, request.Code); err != nil {
+		h.codeError(c, userID, err)
+		return
+	}
+
+	if err := h.mfaRepo.Delete(userID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to disable MFA",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		h.auditLogger.LogFromGin(c, "MFA_DISABLED", "user", userID, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "MFA disabled successfully",
+	})
+}
+
+// ResetUserMFA removes the MFA setup of a user who lost their authenticator and recovery codes
+// This endpoint is meant for admins, the user can enroll again after logging in with their password
+func (h *Handler) ResetUserMFA(c *gin.Context) {
+	userID := c.Param("id")
+
+	if _, err := h.userRepo.GetByID(userID); err != nil {
+		if err == user.ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "User not found",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if err := h.mfaRepo.Delete(userID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to reset MFA",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		h.auditLogger.LogFromGin(c, "MFA_RESET", "user", userID, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "MFA reset successfully",
+	})
+}
+
+// requireEnabled responds with an error unless the user has MFA enabled
+func (h *Handler) requireEnabled(c *gin.Context, userID string) bool {
+	enabled, err := h.mfaRepo.IsEnabled(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get MFA status",
+		})
+		return false
+	}
+	if !enabled {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "MFA is not enabled",
+		})
+		return false
+	}
+	return true
+}
+
+// verifyCode checks a TOTP code, responding with an error when it is wrong
+func (h *Handler) verifyCode(c *gin.Context, userID, code string) bool {
+	if err := h.mfaRepo.VerifyCode(userID, code); err != nil {
+		h.codeError(c, userID, err)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) codeError(c *gin.Context, userID string, err error) {
+	if err == ErrInvalidCode {
+		if h.auditLogger != nil {
+			h.auditLogger.LogFromGin(c, "MFA_FAILED", "user", userID, nil)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid code",
+		})
+		return
+	}
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to verify code",
+	})
+}
@@ -0,0 +1,63 @@
+package mfa
+
+import (
+	"time"
+)
+
+// Ways a user can pass the second login step
+const (
+	MethodTOTP         = "totp"
+	MethodRecoveryCode = "recovery_code"
+	MethodWebAuthn     = "webauthn"
+)
+
+// TOTP enrollments table in the database
+// Secret is held decrypted in memory, it is only encrypted in the database
+type TOTP struct {
+	UserID       string     `json:"user_id"`
+	Secret       string     `json:"-"`
+	LastUsedStep int64      `json:"-"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Status of a user's MFA setup
+type StatusResponse struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// Returned once when enrolling, to be shown as a QR code
+type EnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// For input validation when a user starts a TOTP enrollment
+type EnrollRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// For input validation when a user confirms a TOTP enrollment
+type ConfirmRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// For input validation when a code proves possession of the authenticator
+type CodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// For input validation when a user turns MFA off
+// Code may be a TOTP code or a recovery code
+type DisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// IsEnabled reports whether the enrollment was confirmed
+func (t *TOTP) IsEnabled() bool {
+	return t.EnabledAt != nil
+}
@@ -0,0 +1,302 @@
+package mfa
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/bharabhi01/authservice/pkg/secretbox"
+	"github.com/bharabhi01/authservice/pkg/totp"
+)
+
+var (
+	ErrNotEnrolled    = errors.New("mfa is not enrolled")
+	ErrAlreadyEnabled = errors.New("mfa is already enabled")
+	ErrInvalidCode    = errors.New("invalid mfa code")
+)
+
+// Number of recovery codes handed out at once
+const recoveryCodeCount = 10
+
+// Repository stores TOTP enrollments and recovery codes
+// TOTP secrets are encrypted with box before they reach the database
+type Repository struct {
+	db  *sql.DB
+	box *secretbox.Box
+}
+
+func NewRepository(box *secretbox.Box) *Repository {
+	return &Repository{
+		db:  database.DB,
+		box: box,
+	}
+}
+
+// Get returns the TOTP enrollment of a user with its secret decrypted
+func (r *Repository) Get(userID string) (*TOTP, error) {
+	t := &TOTP{}
+	var sealed string
+
+	query := `
+		SELECT user_id, secret, last_used_step, enabled_at, created_at
+		FROM mfa_totp
+		WHERE user_id = $1
+	`
+
+	err := r.db.QueryRow(query, userID).Scan(
+		&t.UserID,
+		&sealed,
+		&t.LastUsedStep,
+		&t.EnabledAt,
+		&t.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotEnrolled
+		}
+		return nil, err
+	}
+
+	secret, err := r.box.Open(sealed, []byte(userID))
+	if err != nil {
+		return nil, err
+	}
+	t.Secret = string(secret)
+
+	return t, nil
+}
+
+// PasskeyLookup reports whether a user registered passkeys, passkey.RelyingParty satisfies it
+type PasskeyLookup interface {
+	HasCredentials(userID string) (bool, error)
+}
+
+// SecondFactors lists the methods a user can complete a password login with, none means no second step
+// A registered passkey counts as a second factor even without TOTP, since it is as strong as one
+func (r *Repository) SecondFactors(userID string, passkeys PasskeyLookup) ([]string, error) {
+	var methods []string
+
+	enabled, err := r.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		methods = append(methods, MethodTOTP, MethodRecoveryCode)
+	}
+
+	hasPasskeys, err := passkeys.HasCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	if hasPasskeys {
+		methods = append(methods, MethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// IsEnabled reports whether a user has confirmed a TOTP enrollment
+func (r *Repository) IsEnabled(userID string) (bool, error) {
+	var enabled bool
+	query := `SELECT EXISTS(SELECT 1 FROM mfa_totp WHERE user_id = $1 AND enabled_at IS NOT NULL)`
+	err := r.db.QueryRow(query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// StartEnrollment stores a new, not yet confirmed secret for a user
+// A previous unconfirmed enrollment is replaced, a confirmed one returns ErrAlreadyEnabled
+func (r *Repository) StartEnrollment(userID, secret string) error {
+	sealed, err := r.box.Seal([]byte(secret), []byte(userID))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO mfa_totp (user_id, secret, last_used_step, created_at)
+		VALUES ($1, $2, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = EXCLUDED.created_at
+		WHERE mfa_totp.enabled_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, userID, sealed, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Enable confirms the enrollment of a user
+func (r *Repository) Enable(userID string) error {
+	query := `
+		UPDATE mfa_totp
+		SET enabled_at = $2
+		WHERE user_id = $1 AND enabled_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, userID, time.Now())
+	return err
+}
+
+// VerifyCode checks a TOTP code of a user, enrolled or still confirming
+// Each time step is accepted only once, so an intercepted code cannot be replayed
+func (r *Repository) VerifyCode(userID, code string) error {
+	t, err := r.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(t.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	query := `
+		UPDATE mfa_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+
+	result, err := r.db.Exec(query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// Authenticate checks the second factor of an enrolled user, given either a TOTP code or a recovery code
+// It returns the method that was used
+func (r *Repository) Authenticate(userID, code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		if err := r.VerifyCode(userID, code); err != nil {
+			return "", err
+		}
+		return MethodTOTP, nil
+	}
+
+	if err := r.UseRecoveryCode(userID, code); err != nil {
+		return "", err
+	}
+	return MethodRecoveryCode, nil
+}
+
+// ReplaceRecoveryCodes invalidates the recovery codes of a user and returns a new set
+func (r *Repository) ReplaceRecoveryCodes(userID string) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+
+		query := `
+			INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, $3)
+		`
+		if _, err := tx.Exec(query, userID, token.HashToken(normalizeRecoveryCode(code)), now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode spends one of a user's recovery codes
+func (r *Repository) UseRecoveryCode(userID, code string) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, userID, token.HashToken(normalizeRecoveryCode(code)), time.Now())
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (r *Repository) CountRecoveryCodes(userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	err := r.db.QueryRow(query, userID).Scan(&count)
+	return count, err
+}
+
+// Delete removes the TOTP enrollment and recovery codes of a user
+func (r *Repository) Delete(userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mfa_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// newRecoveryCode returns a random code formatted as two groups of five characters
+func newRecoveryCode() (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(secret[:10])
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes the user may have typed
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
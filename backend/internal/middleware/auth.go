@@ -3,28 +3,69 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/bharabhi01/authservice/internal/apikey"
+	"github.com/bharabhi01/authservice/internal/session"
+	"github.com/bharabhi01/authservice/pkg/authcookie"
+	"github.com/bharabhi01/authservice/pkg/denylist"
 	"github.com/bharabhi01/authservice/pkg/jwt"
 )
 
+// PermissionResolver lists the names of the permissions a user holds through their roles
+// auth.Repository satisfies it
+type PermissionResolver interface {
+	GetUserPermissionNames(userID string) ([]string, error)
+}
+
+// Authenticator holds the stores AuthMiddleware consults besides the token signature
+// Permissions is used by RequirePermission to authorize the authenticated user
+type Authenticator struct {
+	Revocations denylist.Store
+	Sessions *session.Repository
+	APIKeys *apikey.Repository
+	// CookieMode also accepts the access token from its HttpOnly cookie
+	CookieMode bool
+	// Tokens without an aud claim are accepted until this time
+	AudiencelessUntil time.Time
+	Permissions PermissionResolver
+}
+
 // AuthMiddleware checks if the user is authenticated
-func AuthMiddleware() gin.HandlerFunc {
+// Tokens that were revoked through the denylist, or whose session was revoked, are rejected even if they have not expired
+// API keys are accepted in the X-API-Key header or as a Bearer token
+// In cookie mode a request without an Authorization header may carry the access token in a cookie
+func AuthMiddleware(auth *Authenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			authenticateAPIKey(c, auth, key)
+			return
+		}
+
 		// Get the Authorization header from the request
 		authHeader := c.GetHeader("Authorization")
 
-		// Check if the header is present and starts with "Bearer "
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		var tokenString string
+		if authHeader == "" && auth.CookieMode {
+			tokenString, _ = c.Cookie(authcookie.AccessTokenName)
+		} else if strings.HasPrefix(authHeader, "Bearer ") {
+			// Extract the token string from the header
+			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+		}
+
+		// Check if a token was found in the header or the cookie
+		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Authorization header is required and must be a Bearer token",
 			})
 			c.Abort()
 			return
 		}
-
-		// Extract the token string from the header
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if apikey.IsAPIKey(tokenString) {
+			authenticateAPIKey(c, auth, tokenString)
+			return
+		}
 
 		// Validate the token
 		claims, err := jwt.ValidateToken(tokenString)
@@ -36,21 +77,192 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Check the token was minted for this service and not for another of our apps
+		// Tokens issued before the aud claim was introduced carry no audience, they are accepted during the configured transition only
+		if len(claims.Audience) == 0 && time.Now().After(auth.AudiencelessUntil) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Token has no audience, log in again",
+			})
+			c.Abort()
+			return
+		}
+		if len(claims.Audience) > 0 && !claims.HasAudience(jwt.DefaultAudience()) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Token was not issued for this service",
+			})
+			c.Abort()
+			return
+		}
+
+		// Check the token has not been revoked by a logout or an admin
+		revoked, err := denylist.IsTokenRevoked(c.Request.Context(), auth.Revocations, claims.Id, claims.Principal(), claims.IssuedTime())
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check token revocation",
+			})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Token has been revoked",
+			})
+			c.Abort()
+			return
+		}
+
+		// An impersonation token also dies with the tokens of the admin behind it
+		if claims.IsImpersonation() {
+			revoked, err := denylist.IsTokenRevoked(c.Request.Context(), auth.Revocations, "", claims.Actor.Subject, claims.IssuedTime())
+			if err != nil {
+				c.Error(err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to check token revocation",
+				})
+				c.Abort()
+				return
+			}
+			if revoked {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Token has been revoked",
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		// Check the session the token belongs to is still active, and record that it was used
+		if claims.SessionId != "" {
+			active, err := auth.Sessions.Validate(claims.SessionId)
+			if err != nil {
+				c.Error(err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to check session",
+				})
+				c.Abort()
+				return
+			}
+			if !active {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Session has been revoked",
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("claims", claims)
+		c.Set(authenticatorKey, auth)
+
+		// Client credentials tokens identify a service instead of a user
+		if claims.IsClient() {
+			c.Set("clientID", claims.ClientId)
+			c.Set("scope", claims.Scope)
+			c.Next()
+			return
+		}
+
 		// Set user information in the context
 		// This makes user data available to handlers
 		c.Set("userID", claims.UserId)
 		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
+		c.Set("roles", claims.RoleNames())
+		c.Set("sessionID", claims.SessionId)
+		if claims.IsImpersonation() {
+			c.Set("actorID", claims.Actor.Subject)
+		}
 
 		c.Next()
 	}
 }
 
-// RoleMiddleware checks if the user has the required role
-func RoleMiddleware(requiredRoles ...string) gin.HandlerFunc {
+// authenticateAPIKey authenticates a request made with an API key
+// The key's permissions are exposed as the scope of the claims, RequirePermission only grants those among them
+// and DenyAPIKey keeps keys away from the account management endpoints under /users/me
+func authenticateAPIKey(c *gin.Context, auth *Authenticator, key string) {
+	k, owner, err := auth.APIKeys.Authenticate(key)
+	if err != nil {
+		if err == apikey.ErrKeyNotFound || err == apikey.ErrKeyInactive {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid, expired or revoked API key",
+			})
+			c.Abort()
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check API key",
+		})
+		c.Abort()
+		return
+	}
+
+	if !owner.Active {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User account is inactive",
+		})
+		c.Abort()
+		return
+	}
+
+	claims := &jwt.Claims{
+		UserId: owner.UserID,
+		Username: owner.Username,
+		Roles: owner.Roles,
+		Scope: strings.Join(k.Scopes, " "),
+	}
+	claims.Subject = owner.UserID
+
+	c.Set("claims", claims)
+	c.Set(authenticatorKey, auth)
+	c.Set("userID", owner.UserID)
+	c.Set("username", owner.Username)
+	c.Set("roles", owner.Roles)
+	c.Set("apiKeyID", k.ID)
+
+	c.Next()
+}
+
+// DenyImpersonation rejects requests made with a token an admin obtained by impersonating a user
+// It guards endpoints where acting as someone else could be used to escalate privileges
+func DenyImpersonation() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get the user role from the context (set by AuthMiddleware)
-		role, exists := c.Get("role")
+		if c.GetString("actorID") != "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Not allowed while impersonating a user",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// DenyAPIKey rejects requests authenticated with an API key
+// It guards account management endpoints, where a narrowly scoped key could otherwise take over the account
+func DenyAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("apiKeyID") != "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Not allowed with an API key",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScopes checks if the token was granted all of the required scopes
+// Tokens from the login endpoints are not limited by scopes and always pass,
+// tokens from OAuth2 flows (including every client token) must carry each scope
+func RequireScopes(requiredScopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the token claims from the context (set by AuthMiddleware)
+		value, exists := c.Get("claims")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Not authenticated",
@@ -59,21 +271,11 @@ func RoleMiddleware(requiredRoles ...string) gin.HandlerFunc {
 			return
 		}
 
-		// Check if the user's role is in the list of required roles
-		userRole := role.(string)
-		hasRequiredRole := false
-		
-		for _, r := range requiredRoles {
-			if userRole == r {
-				hasRequiredRole = true
-				break
-			}
-		}
-
-		// If the user doesn't have any of the required roles, return forbidden
-		if !hasRequiredRole {
+		claims := value.(*jwt.Claims)
+		if (claims.IsClient() || claims.Scope != "") && !claims.HasScopes(requiredScopes...) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(requiredScopes, " ")+`"`)
 			c.JSON(http.StatusForbidden, gin.H{
-				"error": "Insufficient permissions",
+				"error": "Insufficient scope",
 			})
 			c.Abort()
 			return
@@ -81,4 +283,4 @@ func RoleMiddleware(requiredRoles ...string) gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
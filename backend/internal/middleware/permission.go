@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// authenticatorKey holds the Authenticator that accepted the request, set by AuthMiddleware
+	authenticatorKey = "authenticator"
+	// permissionsKey caches the caller's effective permissions for the rest of the request
+	permissionsKey = "permissions"
+)
+
+// RequirePermission checks if the user holds all of the given permissions through their roles
+// Access follows the role_permissions table, so a new role only needs its permissions granted
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted, ok := effectivePermissions(c)
+		if !ok {
+			return
+		}
+
+		for _, permission := range permissions {
+			if !granted[permission] {
+				denyPermission(c, permission)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAnyPermission checks if the user holds at least one of the given permissions through their roles
+func RequireAnyPermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted, ok := effectivePermissions(c)
+		if !ok {
+			return
+		}
+
+		for _, permission := range permissions {
+			if granted[permission] {
+				c.Next()
+				return
+			}
+		}
+
+		denyPermission(c, permissions...)
+	}
+}
+
+// RequireOutranks refuses the request when the user named by the param path parameter holds a permission the caller lacks
+// Managing such a user, for example resetting their email, would be a way to take over their permissions,
+// the same rule role assignment follows for self escalation
+func RequireOutranks(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted, ok := effectivePermissions(c)
+		if !ok {
+			return
+		}
+
+		auth := c.MustGet(authenticatorKey).(*Authenticator)
+		held, err := auth.Permissions.GetUserPermissionNames(c.Param(param))
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check permission",
+			})
+			c.Abort()
+			return
+		}
+
+		for _, permission := range held {
+			if !granted[permission] {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "You cannot manage a user who holds permissions you do not hold",
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// effectivePermissions resolves the caller's permissions, answering the request itself when that fails
+func effectivePermissions(c *gin.Context) (map[string]bool, bool) {
+	if c.GetString("userID") == "" && c.GetString("clientID") == "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Insufficient permissions",
+		})
+		c.Abort()
+		return nil, false
+	}
+
+	granted, err := resolvePermissions(c)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check permission",
+		})
+		c.Abort()
+		return nil, false
+	}
+
+	return granted, true
+}
+
+// resolvePermissions returns the permissions the user holds through their roles, once per request
+// Requests made with an API key or a token issued to an OAuth2 client are scoped:
+// they only keep the permissions that are also among the granted scopes
+// Client credentials tokens act for no user, they hold exactly the permissions named in their scope
+func resolvePermissions(c *gin.Context) (map[string]bool, error) {
+	if value, exists := c.Get(permissionsKey); exists {
+		return value.(map[string]bool), nil
+	}
+
+	userID := c.GetString("userID")
+	granted := make(map[string]bool)
+	if userID == "" {
+		if c.GetString("clientID") != "" {
+			claims := c.MustGet("claims").(*jwt.Claims)
+			for _, scope := range strings.Fields(claims.Scope) {
+				granted[scope] = true
+			}
+			c.Set(permissionsKey, granted)
+		}
+		return granted, nil
+	}
+
+	auth := c.MustGet(authenticatorKey).(*Authenticator)
+	names, err := auth.Permissions.GetUserPermissionNames(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := c.MustGet("claims").(*jwt.Claims)
+	scoped := c.GetString("apiKeyID") != "" || claims.Scope != ""
+	for _, name := range names {
+		if !scoped || claims.HasScopes(name) {
+			granted[name] = true
+		}
+	}
+
+	c.Set(permissionsKey, granted)
+	return granted, nil
+}
+
+func denyPermission(c *gin.Context, permissions ...string) {
+	message := "Insufficient permissions"
+	if len(permissions) == 1 {
+		if c.GetString("apiKeyID") != "" {
+			message = "API key was not granted the permission " + permissions[0]
+		} else if claims := c.MustGet("claims").(*jwt.Claims); claims.Scope != "" && !claims.HasScopes(permissions[0]) {
+			message = "Token was not granted the scope " + permissions[0]
+		}
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
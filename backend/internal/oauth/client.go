@@ -0,0 +1,69 @@
+package oauth
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrClientNotFound = errors.New("client not found")
+
+// ClientStore looks up registered clients
+type ClientStore interface {
+	GetClient(id string) (*Client, error)
+}
+
+// ClientStores tries each store in turn and returns the first match
+type ClientStores []ClientStore
+
+func (s ClientStores) GetClient(id string) (*Client, error) {
+	for _, store := range s {
+		client, err := store.GetClient(id)
+		if err == nil {
+			return client, nil
+		}
+		if err != ErrClientNotFound {
+			return nil, err
+		}
+	}
+	return nil, ErrClientNotFound
+}
+
+// StaticClients is a ClientStore configured from the OIDC_CLIENTS setting
+// Static clients are public clients limited to the authorization code flow
+type StaticClients map[string]*Client
+
+// ParseStaticClients reads clients in the form "id=uri1,uri2;id2=uri3"
+func ParseStaticClients(value string) StaticClients {
+	clients := StaticClients{}
+
+	for _, entry := range strings.Split(value, ";") {
+		id, uris, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || id == "" {
+			continue
+		}
+
+		client := &Client{
+			ClientID:      id,
+			Name:          id,
+			GrantTypes:    []string{"authorization_code", "refresh_token"},
+			AllowedScopes: []string{"openid", "profile", "email"},
+			Active:        true,
+		}
+		for _, uri := range strings.Split(uris, ",") {
+			if uri = strings.TrimSpace(uri); uri != "" {
+				client.RedirectURIs = append(client.RedirectURIs, uri)
+			}
+		}
+		clients[id] = client
+	}
+
+	return clients
+}
+
+func (s StaticClients) GetClient(id string) (*Client, error) {
+	client, ok := s[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	return client, nil
+}
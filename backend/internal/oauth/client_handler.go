@@ -0,0 +1,185 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// ClientHandler handles OAuth2 client registry requests
+// It contains dependencies needed for client administration
+type ClientHandler struct {
+	oauthRepo   *Repository
+	auditLogger *audit.Logger
+}
+
+// NewClientHandler creates a new client handler
+// This function initializes the handler with required dependencies
+func NewClientHandler(oauthRepo *Repository, auditLogger *audit.Logger) *ClientHandler {
+	return &ClientHandler{
+		oauthRepo:   oauthRepo,
+		auditLogger: auditLogger,
+	}
+}
+
+// CreateClient registers a new client
+// The client secret is only returned in this response
+func (h *ClientHandler) CreateClient(c *gin.Context) {
+	var registration ClientRegistration
+	if err := c.ShouldBindJSON(&registration); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid client data: " + err.Error(),
+		})
+		return
+	}
+
+	if contains(registration.GrantTypes, "client_credentials") && !registration.Confidential {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "The client_credentials grant requires a confidential client",
+		})
+		return
+	}
+
+	client, secret, err := h.oauthRepo.CreateClient(&registration)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create client",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"client_id":   client.ClientID,
+			"name":        client.Name,
+			"grant_types": client.GrantTypes,
+		}
+		h.auditLogger.LogFromGin(c, "CLIENT_CREATED", "client", client.ID, details)
+	}
+
+	response := gin.H{
+		"message": "Client created successfully",
+		"client":  client,
+	}
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetClients returns all registered clients
+func (h *ClientHandler) GetClients(c *gin.Context) {
+	clients, err := h.oauthRepo.GetClients()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get clients",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clients": clients,
+	})
+}
+
+// GetClient returns a single client
+func (h *ClientHandler) GetClient(c *gin.Context) {
+	client, err := h.oauthRepo.GetClient(c.Param("id"))
+	if err != nil {
+		h.clientError(c, "Failed to get client", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"client": client,
+	})
+}
+
+// UpdateClient changes the settings of a client
+func (h *ClientHandler) UpdateClient(c *gin.Context) {
+	var update ClientUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid client data: " + err.Error(),
+		})
+		return
+	}
+
+	client, err := h.oauthRepo.UpdateClient(c.Param("id"), &update)
+	if err != nil {
+		h.clientError(c, "Failed to update client", err)
+		return
+	}
+
+	if h.auditLogger != nil {
+		h.auditLogger.LogFromGin(c, "CLIENT_UPDATED", "client", client.ID, update)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Client updated successfully",
+		"client":  client,
+	})
+}
+
+// RotateClientSecret issues a new secret for a client, invalidating the old one
+func (h *ClientHandler) RotateClientSecret(c *gin.Context) {
+	clientID := c.Param("id")
+
+	secret, err := h.oauthRepo.RotateClientSecret(clientID)
+	if err != nil {
+		h.clientError(c, "Failed to rotate client secret", err)
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"client_id": clientID,
+		}
+		h.auditLogger.LogFromGin(c, "CLIENT_SECRET_ROTATED", "client", clientID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Client secret rotated successfully",
+		"client_secret": secret,
+	})
+}
+
+// DeleteClient removes a client
+// Tokens already issued to the client stay valid until they expire
+func (h *ClientHandler) DeleteClient(c *gin.Context) {
+	clientID := c.Param("id")
+
+	if err := h.oauthRepo.DeleteClient(clientID); err != nil {
+		h.clientError(c, "Failed to delete client", err)
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"client_id": clientID,
+		}
+		h.auditLogger.LogFromGin(c, "CLIENT_DELETED", "client", clientID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Client deleted successfully",
+	})
+}
+
+func (h *ClientHandler) clientError(c *gin.Context, message string, err error) {
+	if err == ErrClientNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Client not found",
+		})
+		return
+	}
+
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": message,
+	})
+}
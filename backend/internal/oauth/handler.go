@@ -0,0 +1,735 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/mfa"
+	"github.com/bharabhi01/authservice/internal/passkey"
+	"github.com/bharabhi01/authservice/internal/session"
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/bharabhi01/authservice/pkg/authcookie"
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/bharabhi01/authservice/pkg/denylist"
+	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/bharabhi01/authservice/pkg/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+// Authorization codes are short lived, they only need to survive the redirect back to the client
+const authorizationCodeLifetime = 10 * time.Minute
+
+// Handler implements the OpenID Connect provider endpoints
+type Handler struct {
+	userRepo    *user.Repository
+	tokenRepo   *token.Repository
+	sessionRepo *session.Repository
+	oauthRepo   *Repository
+	clients     ClientStore
+	mfaRepo     *mfa.Repository
+	passkeys    *passkey.RelyingParty
+	revocations denylist.Store
+	limiter     *throttle.Limiter
+	auditLogger *audit.Logger
+	cfg         *config.Config
+}
+
+func NewHandler(userRepo *user.Repository, tokenRepo *token.Repository, sessionRepo *session.Repository, oauthRepo *Repository, clients ClientStore, mfaRepo *mfa.Repository, passkeys *passkey.RelyingParty, revocations denylist.Store, limiter *throttle.Limiter, auditLogger *audit.Logger, cfg *config.Config) *Handler {
+	return &Handler{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		sessionRepo: sessionRepo,
+		oauthRepo:   oauthRepo,
+		clients:     clients,
+		mfaRepo:     mfaRepo,
+		passkeys:    passkeys,
+		revocations: revocations,
+		limiter:     limiter,
+		auditLogger: auditLogger,
+		cfg:         cfg,
+	}
+}
+
+// Discovery returns the OpenID Provider metadata
+func (h *Handler) Discovery(c *gin.Context) {
+	issuer := strings.TrimRight(h.cfg.IssuerURL, "/")
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                        issuer,
+		"authorization_endpoint":                        issuer + "/oauth2/authorize",
+		"token_endpoint":                                issuer + "/oauth2/token",
+		"userinfo_endpoint":                             issuer + "/userinfo",
+		"introspection_endpoint":                        issuer + "/oauth2/introspect",
+		"revocation_endpoint":                           issuer + "/oauth2/revoke",
+		"jwks_uri":                                      issuer + "/.well-known/jwks.json",
+		"response_types_supported":                      []string{"code"},
+		"grant_types_supported":                         []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         []string{h.cfg.JWTSigningAlg},
+		"scopes_supported":                              []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported":         []string{"none", "client_secret_basic", "client_secret_post"},
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"revocation_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":              []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"preferred_username", "name", "given_name", "family_name", "updated_at",
+			"email", "email_verified",
+		},
+	})
+}
+
+// Authorize runs the authorization code flow
+// GET shows the sign-in form, POST checks the credentials and redirects back with a code
+func (h *Handler) Authorize(c *gin.Context) {
+	var request AuthorizeRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid authorization request: "+err.Error())
+		return
+	}
+
+	// Without a trusted redirect URI, errors must not be sent back to the client
+	client, err := h.clients.GetClient(request.ClientID)
+	if err != nil || !client.Active {
+		c.String(http.StatusBadRequest, "Unknown client_id")
+		return
+	}
+	if !client.AllowsRedirect(request.RedirectURI) {
+		c.String(http.StatusBadRequest, "redirect_uri is not registered for this client")
+		return
+	}
+
+	if request.ResponseType != "code" || !client.AllowsGrant("authorization_code") {
+		redirectError(c, request, "unsupported_response_type", "Only the code response type is supported")
+		return
+	}
+	if !HasScope(request.Scope, "openid") || !client.AllowsScopes(request.Scope) {
+		redirectError(c, request, "invalid_scope", "The openid scope is required and every scope must be allowed for the client")
+		return
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		redirectError(c, request, "invalid_request", "PKCE with code_challenge_method S256 is required")
+		return
+	}
+
+	if c.Request.Method == http.MethodGet {
+		h.renderLoginForm(c, http.StatusOK, request, "")
+		return
+	}
+
+	// Without this a third party site could sign the browser into an account of its choosing
+	if !authcookie.VerifyFormCSRF(c) {
+		h.renderLoginForm(c, http.StatusForbidden, request, "The sign-in form expired, please try again")
+		return
+	}
+
+	// The attempt counts as failed until the credentials and code prove right
+	attempt, err := h.limiter.Attempt(c.Request.Context(), request.Username, c.ClientIP())
+	if err != nil {
+		redirectError(c, request, "server_error", "Failed to check login attempts")
+		return
+	}
+	if !attempt.Allowed() {
+		// Attempts held back still count, so one of them may be the one that locks the account
+		if attempt.LocksAccount && h.auditLogger != nil {
+			h.auditAccountLocked(c, request.Username, h.userIDOf(request.Username))
+		}
+		c.Header("Retry-After", strconv.Itoa(attempt.RetryAfterSeconds()))
+		h.renderLoginForm(c, http.StatusTooManyRequests, request, "Too many failed login attempts, try again later")
+		return
+	}
+
+	u, err := h.userRepo.Authenticate(request.Username, request.Password)
+	if err != nil {
+		message := "Invalid credentials"
+		if err == user.ErrInactive {
+			message = "Account is not active"
+			h.releaseLoginAttempt(c, request.Username)
+		} else {
+			h.recordLoginFailure(c, request.Username, "invalid_credentials", attempt)
+		}
+		h.renderLoginForm(c, http.StatusUnauthorized, request, message)
+		return
+	}
+
+	if h.cfg.RequireEmailVerification && !u.EmailVerified {
+		h.releaseLoginAttempt(c, request.Username)
+		h.renderLoginForm(c, http.StatusForbidden, request, "Email address is not verified")
+		return
+	}
+
+	// Users with MFA enter their authentication code in the same form
+	// The second factors are the same as for /login, so this form cannot be used to skip one
+	methods, err := h.mfaRepo.SecondFactors(u.ID, h.passkeys)
+	if err != nil {
+		h.releaseLoginAttempt(c, request.Username)
+		redirectError(c, request, "server_error", "Failed to get MFA status")
+		return
+	}
+	if len(methods) > 0 && !slices.Contains(methods, mfa.MethodTOTP) {
+		// The form has no WebAuthn ceremony, so an account whose only second factor is a passkey cannot finish here
+		h.releaseLoginAttempt(c, request.Username)
+		h.renderLoginForm(c, http.StatusForbidden, request, "This account signs in with a passkey, which this form does not support")
+		return
+	}
+	if len(methods) > 0 {
+		if request.OTP == "" {
+			h.releaseLoginAttempt(c, request.Username)
+			h.renderLoginForm(c, http.StatusUnauthorized, request, "Enter the code from your authenticator app")
+			return
+		}
+		if _, err := h.mfaRepo.Authenticate(u.ID, request.OTP); err != nil {
+			if h.auditLogger != nil {
+				h.auditLogger.LogFromGin(c, "MFA_FAILED", "user", u.ID, nil)
+			}
+			h.recordLoginFailure(c, request.Username, "invalid_mfa_code", attempt)
+			h.renderLoginForm(c, http.StatusUnauthorized, request, "Invalid authentication code")
+			return
+		}
+	}
+
+	h.releaseLoginAttempt(c, request.Username)
+	if err := h.limiter.Succeed(c.Request.Context(), u.Username); err != nil {
+		log.Printf("Failed to reset login failures of user %s: %v", u.ID, err)
+	}
+
+	s, err := h.sessionRepo.Create(u.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		redirectError(c, request, "server_error", "Failed to create session")
+		return
+	}
+
+	now := time.Now()
+	code, err := h.oauthRepo.CreateCode(&AuthorizationCode{
+		ClientID:            client.ClientID,
+		UserID:              u.ID,
+		SessionID:           s.ID,
+		RedirectURI:         request.RedirectURI,
+		Scope:               request.Scope,
+		Nonce:               request.Nonce,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		redirectError(c, request, "server_error", "Failed to create authorization code")
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"username":  u.Username,
+			"client_id": client.ClientID,
+			"scope":     request.Scope,
+		}
+		h.auditLogger.LogFromGin(c, "OAUTH_AUTHORIZE", "user", u.ID, details)
+	}
+
+	redirect(c, request.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {request.State},
+	})
+}
+
+// Token exchanges an authorization code or a refresh token for tokens
+func (h *Handler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var request TokenRequest
+	if err := c.ShouldBind(&request); err != nil {
+		tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := h.authenticateClient(c, request.ClientID, request.ClientSecret)
+	if !ok {
+		return
+	}
+
+	if !client.AllowsGrant(request.GrantType) {
+		tokenError(c, http.StatusBadRequest, "unauthorized_client", "Client is not allowed to use this grant type")
+		return
+	}
+
+	switch request.GrantType {
+	case "authorization_code":
+		h.exchangeCode(c, client, request)
+	case "refresh_token":
+		h.exchangeRefreshToken(c, client, request)
+	case "client_credentials":
+		h.clientCredentials(c, client, request)
+	default:
+		tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "Grant type is not supported")
+	}
+}
+
+// authenticateClient identifies the client calling a token endpoint
+// Confidential clients authenticate with HTTP Basic or client_secret in the body, public clients only send client_id
+func (h *Handler) authenticateClient(c *gin.Context, clientID, clientSecret string) (*Client, bool) {
+	basicID, basicSecret, basic := c.Request.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(basicID)
+		clientSecret, _ = url.QueryUnescape(basicSecret)
+	}
+
+	fail := func() (*Client, bool) {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth2"`)
+		}
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return nil, false
+	}
+
+	client, err := h.clients.GetClient(clientID)
+	if err == ErrClientNotFound {
+		return fail()
+	}
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "Failed to load client")
+		return nil, false
+	}
+	if !client.Active {
+		return fail()
+	}
+
+	if client.IsPublic() {
+		if clientSecret != "" {
+			return fail()
+		}
+		return client, true
+	}
+
+	if !VerifyClientSecret(client, clientSecret) {
+		return fail()
+	}
+
+	return client, true
+}
+
+func (h *Handler) exchangeCode(c *gin.Context, client *Client, request TokenRequest) {
+	if request.Code == "" || request.CodeVerifier == "" {
+		tokenError(c, http.StatusBadRequest, "invalid_request", "code and code_verifier are required")
+		return
+	}
+
+	// The client is already authenticated, the code is only used up once it also proves the redirect_uri and code_verifier
+	code, err := h.oauthRepo.ConsumeCode(request.Code, func(code *AuthorizationCode) error {
+		if code.ClientID != client.ClientID || code.RedirectURI != request.RedirectURI {
+			return ErrCodeMismatch
+		}
+		if !verifyCodeChallenge(code.CodeChallenge, request.CodeVerifier) {
+			return ErrCodeVerifier
+		}
+		return nil
+	})
+	if err != nil {
+		switch err {
+		case ErrCodeInvalid, ErrCodeMismatch, ErrCodeVerifier:
+			tokenError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		default:
+			tokenError(c, http.StatusInternalServerError, "server_error", "Failed to load authorization code")
+		}
+		return
+	}
+
+	audience := strings.Fields(request.Audience)
+	if !client.AllowsAudiences(audience) {
+		tokenError(c, http.StatusBadRequest, "invalid_target", "Requested audience is not allowed for the client")
+		return
+	}
+
+	u, err := h.userRepo.GetByID(code.UserID)
+	if err != nil || !u.Active {
+		tokenError(c, http.StatusBadRequest, "invalid_grant", "Account is not active")
+		return
+	}
+
+	accessToken, err := jwt.Issue(&jwt.Claims{
+		UserId:    u.ID,
+		Username:  u.Username,
+		Roles:     u.Roles,
+		Scope:     code.Scope,
+		Audience:  audience,
+		SessionId: code.SessionID,
+	})
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	refreshToken, err := h.tokenRepo.Create(&token.RefreshToken{
+		UserID:    u.ID,
+		SessionID: code.SessionID,
+		ClientID:  client.ClientID,
+		Scope:     code.Scope,
+		Audience:  audience,
+		ExpiresAt: h.refreshExpiry(),
+	})
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "Failed to generate refresh token")
+		return
+	}
+
+	idToken, err := h.generateIDToken(u, code)
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "Failed to generate ID token")
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"client_id":  code.ClientID,
+			"grant_type": request.GrantType,
+		}
+		h.auditLogger.LogFromGin(c, "OAUTH_TOKEN", "user", u.ID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(jwt.Lifetime().Seconds()),
+		"refresh_token": refreshToken,
+		"id_token":      idToken,
+		"scope":         code.Scope,
+	})
+}
+
+func (h *Handler) exchangeRefreshToken(c *gin.Context, client *Client, request TokenRequest) {
+	if request.RefreshToken == "" {
+		tokenError(c, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	// Refresh tokens are bound to the client they were issued to
+	current, err := h.tokenRepo.GetByToken(request.RefreshToken)
+	if err == nil && current.ClientID != client.ClientID {
+		tokenError(c, http.StatusBadRequest, "invalid_grant", "Refresh token was issued to another client")
+		return
+	}
+
+	refreshToken, next, err := h.tokenRepo.Rotate(request.RefreshToken, h.refreshExpiry())
+	if err != nil {
+		if err == token.ErrTokenReused && next.SessionID != "" {
+			h.sessionRepo.RevokeByID(next.SessionID)
+		}
+		switch err {
+		case token.ErrTokenReused, token.ErrTokenNotFound, token.ErrTokenExpired:
+			tokenError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		default:
+			tokenError(c, http.StatusInternalServerError, "server_error", "Failed to refresh token")
+		}
+		return
+	}
+
+	u, err := h.userRepo.GetByID(next.UserID)
+	if err != nil || !u.Active {
+		h.tokenRepo.RevokeFamily(next.FamilyID)
+		tokenError(c, http.StatusBadRequest, "invalid_grant", "Account is not active")
+		return
+	}
+
+	// Refreshing keeps the session alive, unless it was revoked or has expired in the meantime
+	if next.SessionID != "" {
+		active, err := h.sessionRepo.Validate(next.SessionID)
+		if err != nil {
+			tokenError(c, http.StatusInternalServerError, "server_error", "Failed to check session")
+			return
+		}
+		if !active {
+			h.tokenRepo.RevokeFamily(next.FamilyID)
+			tokenError(c, http.StatusBadRequest, "invalid_grant", "Session has expired or been revoked")
+			return
+		}
+	}
+
+	accessToken, err := jwt.Issue(&jwt.Claims{
+		UserId:    u.ID,
+		Username:  u.Username,
+		Roles:     u.Roles,
+		Scope:     next.Scope,
+		Audience:  next.Audience,
+		SessionId: next.SessionID,
+	})
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(jwt.Lifetime().Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         next.Scope,
+	})
+}
+
+// clientCredentials issues a token that identifies the client itself, for service-to-service calls
+func (h *Handler) clientCredentials(c *gin.Context, client *Client, request TokenRequest) {
+	if client.IsPublic() {
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "Public clients cannot use the client_credentials grant")
+		return
+	}
+
+	scope := request.Scope
+	if scope == "" {
+		scope = strings.Join(client.AllowedScopes, " ")
+	}
+	if !client.AllowsScopes(scope) {
+		tokenError(c, http.StatusBadRequest, "invalid_scope", "Requested scope is not allowed for the client")
+		return
+	}
+
+	audience := strings.Fields(request.Audience)
+	if !client.AllowsAudiences(audience) {
+		tokenError(c, http.StatusBadRequest, "invalid_target", "Requested audience is not allowed for the client")
+		return
+	}
+
+	lifetime := time.Duration(client.AccessTokenLifetime) * time.Second
+	accessToken, err := jwt.GenerateClientToken(client.ClientID, scope, audience, lifetime)
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"client_id":  client.ClientID,
+			"grant_type": request.GrantType,
+			"scope":      scope,
+		}
+		h.auditLogger.LogFromGin(c, "OAUTH_TOKEN", "client", client.ID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   client.AccessTokenLifetime,
+		"scope":        scope,
+	})
+}
+
+// UserInfo returns the OpenID Connect standard claims for the bearer of the access token
+func (h *Handler) UserInfo(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid_token",
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(userID.(string))
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid_token",
+		})
+		return
+	}
+
+	// Tokens from the login endpoints are not limited by scopes, so every standard claim is released
+	scope := "openid profile email"
+	if value, exists := c.Get("claims"); exists && value.(*jwt.Claims).Scope != "" {
+		scope = value.(*jwt.Claims).Scope
+	}
+
+	claims := standardClaims(u, scope)
+	claims.Subject = u.ID
+
+	c.JSON(http.StatusOK, claims)
+}
+
+// generateIDToken signs the ID token of a user for the client a code was issued to
+// Clients verify it with the JWKS, so a shared HS256 secret is never used for it
+func (h *Handler) generateIDToken(u *user.User, code *AuthorizationCode) (string, error) {
+	if !jwt.Asymmetric() {
+		return "", errors.New("ID tokens need an asymmetric JWT_SIGNING_ALG")
+	}
+
+	now := time.Now()
+
+	claims := standardClaims(u, code.Scope)
+	claims.Nonce = code.Nonce
+	claims.AuthTime = code.AuthTime.Unix()
+	claims.StandardClaims = jwt.StandardClaims{
+		Issuer:    strings.TrimRight(h.cfg.IssuerURL, "/"),
+		Subject:   u.ID,
+		Audience:  code.ClientID,
+		ExpiresAt: now.Add(jwt.Lifetime()).Unix(),
+		IssuedAt:  now.Unix(),
+	}
+
+	return jwt.Sign(claims)
+}
+
+func (h *Handler) refreshExpiry() time.Time {
+	return time.Now().Add(time.Duration(h.cfg.RefreshExpirationDays) * 24 * time.Hour)
+}
+
+// standardClaims maps a user onto the OpenID Connect claims allowed by scope
+func standardClaims(u *user.User, scope string) *IDTokenClaims {
+	claims := &IDTokenClaims{}
+
+	if HasScope(scope, "profile") {
+		claims.PreferredUsername = u.Username
+		claims.Name = strings.TrimSpace(u.FirstName + " " + u.LastName)
+		claims.GivenName = u.FirstName
+		claims.FamilyName = u.LastName
+		claims.UpdatedAt = u.UpdatedAt.Unix()
+	}
+
+	if HasScope(scope, "email") {
+		verified := u.EmailVerified
+		claims.Email = u.Email
+		claims.EmailVerified = &verified
+	}
+
+	return claims
+}
+
+// verifyCodeChallenge checks a PKCE code_verifier against the S256 code_challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func redirectError(c *gin.Context, request AuthorizeRequest, code, description string) {
+	redirect(c, request.RedirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {request.State},
+	})
+}
+
+func redirect(c *gin.Context, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid redirect_uri")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, target.String())
+}
+
+func tokenError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in</title></head>
+<body>
+	<h1>Sign in to {{.Request.ClientID}}</h1>
+	{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+	<form method="POST" action="">
+		<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+		<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+		<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+		<input type="hidden" name="scope" value="{{.Request.Scope}}">
+		<input type="hidden" name="state" value="{{.Request.State}}">
+		<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+		<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+		<input type="hidden" name="form_csrf_token" value="{{.CSRFToken}}">
+		<label>Username <input type="text" name="username" value="{{.Request.Username}}" autofocus></label>
+		<label>Password <input type="password" name="password"></label>
+		<label>Authentication code (if enabled) <input type="text" name="otp" autocomplete="one-time-code"></label>
+		<button type="submit">Sign in</button>
+	</form>
+</body>
+</html>
+`))
+
+// releaseLoginAttempt takes back the attempt counted for the authorization form once the credentials proved right
+func (h *Handler) releaseLoginAttempt(c *gin.Context, username string) {
+	if err := h.limiter.Release(c.Request.Context(), username, c.ClientIP()); err != nil {
+		log.Printf("Failed to release login attempt for %s: %v", username, err)
+	}
+}
+
+// recordLoginFailure audits a failed login on the authorization form, which was counted when it was attempted
+func (h *Handler) recordLoginFailure(c *gin.Context, username, reason string, attempt throttle.Decision) {
+	if h.auditLogger == nil {
+		return
+	}
+
+	userID := h.userIDOf(username)
+
+	details := map[string]interface{}{
+		"username": username,
+		"reason":   reason,
+	}
+	h.auditLogger.LogFromGin(c, "LOGIN_FAILED", "user", userID, details)
+
+	if attempt.LocksAccount {
+		h.auditAccountLocked(c, username, userID)
+	}
+}
+
+// auditAccountLocked records that the attempt just counted locked the account of username
+func (h *Handler) auditAccountLocked(c *gin.Context, username, userID string) {
+	details := map[string]interface{}{
+		"username": username,
+	}
+	h.auditLogger.LogFromGin(c, "ACCOUNT_LOCKED", "user", userID, details)
+}
+
+// userIDOf returns the ID of the user named username, or "" for usernames that do not exist
+func (h *Handler) userIDOf(username string) string {
+	if u, err := h.userRepo.GetByUsername(username); err == nil {
+		return u.ID
+	}
+	return ""
+}
+
+// renderLoginForm shows the sign-in form with a fresh CSRF token
+func (h *Handler) renderLoginForm(c *gin.Context, status int, request AuthorizeRequest, message string) {
+	request.Password = ""
+	request.OTP = ""
+
+	csrfToken, err := authcookie.SetFormCSRF(c, h.cfg, c.Request.URL.Path)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to render the sign-in form")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	loginForm.Execute(c.Writer, gin.H{
+		"Request":   request,
+		"Error":     message,
+		"CSRFToken": csrfToken,
+	})
+}
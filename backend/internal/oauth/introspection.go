@@ -0,0 +1,205 @@
+package oauth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/pkg/denylist"
+	"github.com/bharabhi01/authservice/pkg/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// Introspect reports whether an access or refresh token is active, as defined by RFC 7662
+func (h *Handler) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	var request TokenLookupRequest
+	if err := c.ShouldBind(&request); err != nil {
+		tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if _, ok := h.authenticateConfidentialClient(c, request); !ok {
+		return
+	}
+
+	lookups := []func(*gin.Context, string) (*IntrospectionResponse, error){h.introspectAccessToken, h.introspectRefreshToken}
+	if request.TokenTypeHint == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		response, err := lookup(c, request.Token)
+		if err != nil {
+			tokenError(c, http.StatusInternalServerError, "server_error", "Failed to introspect token")
+			return
+		}
+		if response != nil {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+}
+
+// Revoke invalidates an access or refresh token, as defined by RFC 7009
+// Unknown or already invalid tokens are not an error, and neither are tokens issued to another client,
+// which are left alone so a client cannot log users out of other applications
+func (h *Handler) Revoke(c *gin.Context) {
+	var request TokenLookupRequest
+	if err := c.ShouldBind(&request); err != nil {
+		tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := h.authenticateConfidentialClient(c, request)
+	if !ok {
+		return
+	}
+
+	if claims, err := jwt.ValidateToken(request.Token); err == nil {
+		if claims.ClientId != client.ClientID {
+			c.Status(http.StatusOK)
+			return
+		}
+		if err := h.revocations.Revoke(c.Request.Context(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			tokenError(c, http.StatusServiceUnavailable, "server_error", "Failed to revoke token")
+			return
+		}
+		h.logRevocation(c, client, "access_token", claims.Principal())
+	} else if refreshToken, err := h.tokenRepo.GetByToken(request.Token); err == nil {
+		if refreshToken.ClientID != client.ClientID {
+			c.Status(http.StatusOK)
+			return
+		}
+		if err := h.tokenRepo.RevokeFamily(refreshToken.FamilyID); err != nil {
+			tokenError(c, http.StatusServiceUnavailable, "server_error", "Failed to revoke token")
+			return
+		}
+		h.logRevocation(c, client, "refresh_token", refreshToken.UserID)
+	} else if err != token.ErrTokenNotFound {
+		tokenError(c, http.StatusServiceUnavailable, "server_error", "Failed to revoke token")
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// authenticateConfidentialClient only lets clients with a secret use introspection and revocation
+func (h *Handler) authenticateConfidentialClient(c *gin.Context, request TokenLookupRequest) (*Client, bool) {
+	client, ok := h.authenticateClient(c, request.ClientID, request.ClientSecret)
+	if !ok {
+		return nil, false
+	}
+
+	if client.IsPublic() {
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "Client authentication is required")
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (h *Handler) introspectAccessToken(c *gin.Context, value string) (*IntrospectionResponse, error) {
+	claims, err := jwt.ValidateToken(value)
+	if err != nil {
+		return nil, nil
+	}
+
+	revoked, err := denylist.IsTokenRevoked(c.Request.Context(), h.revocations, claims.Id, claims.Principal(), claims.IssuedTime())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+
+	if claims.SessionId != "" {
+		active, err := h.sessionRepo.Validate(claims.SessionId)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return &IntrospectionResponse{Active: false}, nil
+		}
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		TokenType: "access_token",
+		Sub:       claims.Principal(),
+		Username:  claims.Username,
+		Role:      legacyRole(claims.RoleNames()),
+		Roles:     claims.RoleNames(),
+		ClientID:  claims.ClientId,
+		Scope:     claims.Scope,
+		Aud:       claims.Audience,
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+	}, nil
+}
+
+func (h *Handler) introspectRefreshToken(c *gin.Context, value string) (*IntrospectionResponse, error) {
+	refreshToken, err := h.tokenRepo.GetByToken(value)
+	if err == token.ErrTokenNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !refreshToken.IsActive() {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+
+	u, err := h.userRepo.GetByID(refreshToken.UserID)
+	if err != nil || !u.Active {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		TokenType: "refresh_token",
+		Sub:       u.ID,
+		Username:  u.Username,
+		Role:      legacyRole(u.Roles),
+		Roles:     u.Roles,
+		ClientID:  refreshToken.ClientID,
+		Scope:     refreshToken.Scope,
+		Aud:       refreshToken.Audience,
+		Exp:       refreshToken.ExpiresAt.Unix(),
+		Iat:       refreshToken.CreatedAt.Unix(),
+		Iss:       "authservice",
+	}, nil
+}
+
+// legacyRole picks the role to report to resource servers that only know a single role
+// admin wins, so nobody who is an admin shows up as an ordinary user
+func legacyRole(roles []string) string {
+	for _, role := range roles {
+		if role == "admin" {
+			return role
+		}
+	}
+	if len(roles) > 0 {
+		return roles[0]
+	}
+	return ""
+}
+
+func (h *Handler) logRevocation(c *gin.Context, client *Client, tokenType, subject string) {
+	if h.auditLogger == nil {
+		return
+	}
+
+	details := map[string]interface{}{
+		"client_id":  client.ClientID,
+		"token_type": tokenType,
+		"subject":    subject,
+	}
+	h.auditLogger.LogFromGin(c, "TOKEN_REVOKED", "token", subject, details)
+}
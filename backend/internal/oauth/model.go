@@ -0,0 +1,192 @@
+package oauth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bharabhi01/authservice/pkg/jwt"
+)
+
+// OAuth2 authorization codes table in the database
+// Code holds the SHA-256 hash of the value handed to the client
+type AuthorizationCode struct {
+	ID                  string
+	Code                string
+	ClientID            string
+	UserID              string
+	SessionID           string
+	RedirectURI         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+	CreatedAt           time.Time
+}
+
+// OAuth2 clients table in the database
+// SecretHash is empty for public clients
+type Client struct {
+	ID                  string    `json:"id"`
+	ClientID            string    `json:"client_id"`
+	Name                string    `json:"name"`
+	SecretHash          string    `json:"-"`
+	RedirectURIs        []string  `json:"redirect_uris"`
+	GrantTypes          []string  `json:"grant_types"`
+	AllowedScopes       []string  `json:"allowed_scopes"`
+	Audiences           []string  `json:"audiences"`
+	AccessTokenLifetime int       `json:"access_token_lifetime"`
+	Active              bool      `json:"active"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// For input validation when registering a client
+type ClientRegistration struct {
+	Name                string   `json:"name" binding:"required,max=100"`
+	Confidential        bool     `json:"confidential"`
+	RedirectURIs        []string `json:"redirect_uris"`
+	GrantTypes          []string `json:"grant_types" binding:"required,min=1,dive,oneof=authorization_code refresh_token client_credentials"`
+	AllowedScopes       []string `json:"allowed_scopes"`
+	Audiences           []string `json:"audiences"`
+	AccessTokenLifetime int      `json:"access_token_lifetime" binding:"omitempty,min=60"`
+}
+
+// For input validation when updating a client, nil fields are left unchanged
+type ClientUpdate struct {
+	Name                *string  `json:"name" binding:"omitempty,max=100"`
+	RedirectURIs        []string `json:"redirect_uris"`
+	GrantTypes          []string `json:"grant_types" binding:"omitempty,dive,oneof=authorization_code refresh_token client_credentials"`
+	AllowedScopes       []string `json:"allowed_scopes"`
+	Audiences           []string `json:"audiences"`
+	AccessTokenLifetime *int     `json:"access_token_lifetime" binding:"omitempty,min=60"`
+	Active              *bool    `json:"active"`
+}
+
+// IsPublic reports whether the client has no secret and cannot authenticate itself
+func (c *Client) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirect reports whether uri exactly matches one of the registered redirect URIs
+func (c *Client) AllowsRedirect(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrant reports whether the client may use a grant type
+func (c *Client) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsScopes reports whether every scope in a space separated string was granted to the client
+func (c *Client) AllowsScopes(scopes string) bool {
+	for _, scope := range strings.Fields(scopes) {
+		if !contains(c.AllowedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsAudiences reports whether the client may request tokens for every one of audiences
+// The default audience of this service is always allowed
+func (c *Client) AllowsAudiences(audiences []string) bool {
+	for _, audience := range audiences {
+		if audience != jwt.DefaultAudience() && !contains(c.Audiences, audience) {
+			return false
+		}
+	}
+	return true
+}
+
+// For input validation on the authorization endpoint
+// Parameters arrive in the query string (GET) or a form body (POST)
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Username            string `form:"username"`
+	Password            string `form:"password"`
+	OTP                 string `form:"otp"`
+}
+
+// For input validation on the token endpoint
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	Audience     string `form:"audience"`
+}
+
+// For input validation on the introspection and revocation endpoints
+type TokenLookupRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// IntrospectionResponse is the RFC 7662 token introspection response
+// Only Active is set for tokens that are unknown, expired or revoked
+// Role is the single role resource servers read before roles existed, kept until they have moved to Roles
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+// IDTokenClaims is the OpenID Connect ID token
+// Profile and email claims are only filled in when the matching scope was granted
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	UpdatedAt         int64  `json:"updated_at,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	jwt.StandardClaims
+}
+
+// HasScope reports whether a space separated scope string contains scope
+func HasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
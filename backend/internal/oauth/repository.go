@@ -0,0 +1,405 @@
+package oauth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrCodeInvalid         = errors.New("authorization code is invalid, expired or already used")
+	ErrCodeMismatch        = errors.New("authorization code was issued to another client or redirect_uri")
+	ErrCodeVerifier        = errors.New("code_verifier does not match code_challenge")
+	ErrInvalidClientSecret = errors.New("invalid client credentials")
+)
+
+// Default access token lifetime for clients that do not set one, in seconds
+const defaultAccessTokenLifetime = 3600
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		db: database.DB,
+	}
+}
+
+// CreateCode stores a new authorization code and returns its plaintext value
+func (r *Repository) CreateCode(code *AuthorizationCode) (string, error) {
+	plaintext, err := token.RandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	code.Code = token.HashToken(plaintext)
+	code.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO oauth_authorization_codes (code, client_id, user_id, session_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`
+
+	err = r.db.QueryRow(
+		query,
+		code.Code,
+		code.ClientID,
+		code.UserID,
+		sql.NullString{String: code.SessionID, Valid: code.SessionID != ""},
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.CodeChallengeMethod,
+		code.AuthTime,
+		code.ExpiresAt,
+		code.CreatedAt,
+	).Scan(&code.ID)
+
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// ConsumeCode marks an authorization code as used and returns it
+// A code can only be consumed once, and only before it expires
+// check sees the code first, a code it refuses is left unused with its error returned,
+// so a caller who cannot prove they own the code cannot use it up either
+func (r *Repository) ConsumeCode(plaintext string, check func(*AuthorizationCode) error) (*AuthorizationCode, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	code := &AuthorizationCode{}
+	var sessionID sql.NullString
+	now := time.Now()
+
+	query := `
+		SELECT id, code, client_id, user_id, session_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, expires_at, used_at, created_at
+		FROM oauth_authorization_codes
+		WHERE code = $1 AND used_at IS NULL AND expires_at > $2
+		FOR UPDATE
+	`
+
+	err = tx.QueryRow(query, token.HashToken(plaintext), now).Scan(
+		&code.ID,
+		&code.Code,
+		&code.ClientID,
+		&code.UserID,
+		&sessionID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.CodeChallengeMethod,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&code.UsedAt,
+		&code.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCodeInvalid
+		}
+		return nil, err
+	}
+	code.SessionID = sessionID.String
+
+	if err := check(code); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE oauth_authorization_codes SET used_at = $2 WHERE id = $1`, code.ID, now); err != nil {
+		return nil, err
+	}
+	code.UsedAt = &now
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
+
+// CreateClient registers a new client
+// Confidential clients get a secret, which is returned in plaintext only here
+func (r *Repository) CreateClient(registration *ClientRegistration) (*Client, string, error) {
+	clientID, err := token.RandomString(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	client := &Client{
+		ClientID:            clientID,
+		Name:                registration.Name,
+		RedirectURIs:        nonNil(registration.RedirectURIs),
+		GrantTypes:          registration.GrantTypes,
+		AllowedScopes:       nonNil(registration.AllowedScopes),
+		Audiences:           nonNil(registration.Audiences),
+		AccessTokenLifetime: registration.AccessTokenLifetime,
+		Active:              true,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	if client.AccessTokenLifetime == 0 {
+		client.AccessTokenLifetime = defaultAccessTokenLifetime
+	}
+
+	var secret string
+	if registration.Confidential {
+		secret, client.SecretHash, err = newClientSecret()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	query := `
+		INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris, grant_types, allowed_scopes, audiences, access_token_lifetime, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`
+
+	err = r.db.QueryRow(
+		query,
+		client.ClientID,
+		client.Name,
+		client.SecretHash,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.GrantTypes),
+		pq.Array(client.AllowedScopes),
+		pq.Array(client.Audiences),
+		client.AccessTokenLifetime,
+		client.Active,
+		client.CreatedAt,
+		client.UpdatedAt,
+	).Scan(&client.ID)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+// GetClient looks up a client by its client_id
+func (r *Repository) GetClient(clientID string) (*Client, error) {
+	query := `
+		SELECT id, client_id, name, secret_hash, redirect_uris, grant_types, allowed_scopes, audiences, access_token_lifetime, active, created_at, updated_at
+		FROM oauth_clients
+		WHERE client_id = $1
+	`
+
+	client, err := scanClient(r.db.QueryRow(query, clientID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrClientNotFound
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// GetClients returns every registered client
+func (r *Repository) GetClients() ([]Client, error) {
+	query := `
+		SELECT id, client_id, name, secret_hash, redirect_uris, grant_types, allowed_scopes, audiences, access_token_lifetime, active, created_at, updated_at
+		FROM oauth_clients
+		ORDER BY name
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// UpdateClient applies the non-nil fields of update to a client
+func (r *Repository) UpdateClient(clientID string, update *ClientUpdate) (*Client, error) {
+	client, err := r.GetClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		client.Name = *update.Name
+	}
+	if update.RedirectURIs != nil {
+		client.RedirectURIs = update.RedirectURIs
+	}
+	if update.GrantTypes != nil {
+		client.GrantTypes = update.GrantTypes
+	}
+	if update.AllowedScopes != nil {
+		client.AllowedScopes = update.AllowedScopes
+	}
+	if update.Audiences != nil {
+		client.Audiences = update.Audiences
+	}
+	if update.AccessTokenLifetime != nil {
+		client.AccessTokenLifetime = *update.AccessTokenLifetime
+	}
+	if update.Active != nil {
+		client.Active = *update.Active
+	}
+	client.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE oauth_clients
+		SET name = $2, redirect_uris = $3, grant_types = $4, allowed_scopes = $5, audiences = $6, access_token_lifetime = $7, active = $8, updated_at = $9
+		WHERE client_id = $1
+	`
+
+	_, err = r.db.Exec(
+		query,
+		client.ClientID,
+		client.Name,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.GrantTypes),
+		pq.Array(client.AllowedScopes),
+		pq.Array(client.Audiences),
+		client.AccessTokenLifetime,
+		client.Active,
+		client.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// RotateClientSecret replaces the secret of a client and returns the new plaintext secret
+// Rotating the secret of a public client turns it into a confidential one
+func (r *Repository) RotateClientSecret(clientID string) (string, error) {
+	secret, secretHash, err := newClientSecret()
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+		UPDATE oauth_clients
+		SET secret_hash = $2, updated_at = $3
+		WHERE client_id = $1
+	`
+
+	result, err := r.db.Exec(query, clientID, secretHash, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if affected == 0 {
+		return "", ErrClientNotFound
+	}
+
+	return secret, nil
+}
+
+// DeleteClient removes a client
+func (r *Repository) DeleteClient(clientID string) error {
+	query := `
+		DELETE FROM oauth_clients
+		WHERE client_id = $1
+	`
+
+	result, err := r.db.Exec(query, clientID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrClientNotFound
+	}
+
+	return nil
+}
+
+// VerifyClientSecret checks a client secret against the stored hash
+func VerifyClientSecret(client *Client, secret string) bool {
+	if client.IsPublic() {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) == nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row scanner) (*Client, error) {
+	client := &Client{}
+
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.Name,
+		&client.SecretHash,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.GrantTypes),
+		pq.Array(&client.AllowedScopes),
+		pq.Array(&client.Audiences),
+		&client.AccessTokenLifetime,
+		&client.Active,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func newClientSecret() (string, string, error) {
+	secret, err := token.RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, string(hash), nil
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,196 @@
+package passkey
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bharabhi01/authservice/internal/reauth"
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/bharabhi01/authservice/pkg/mailer"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler handles passkey registration and removal for the current user
+type Handler struct {
+	relyingParty *RelyingParty
+	passkeyRepo  *Repository
+	userRepo     *user.Repository
+	verifier     *reauth.Verifier
+	mailer       mailer.Mailer
+	auditLogger  *audit.Logger
+}
+
+func NewHandler(relyingParty *RelyingParty, passkeyRepo *Repository, userRepo *user.Repository, verifier *reauth.Verifier, mailer mailer.Mailer, auditLogger *audit.Logger) *Handler {
+	return &Handler{
+		relyingParty: relyingParty,
+		passkeyRepo:  passkeyRepo,
+		userRepo:     userRepo,
+		verifier:     verifier,
+		mailer:       mailer,
+		auditLogger:  auditLogger,
+	}
+}
+
+// BeginRegistration starts registering a passkey for the current user, given their current password
+// The options are passed to navigator.credentials.create() and the session ID back to FinishRegistration
+func (h *Handler) BeginRegistration(c *gin.Context) {
+	var request RegistrationBeginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid passkey registration: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(c.GetString("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	if !h.verifier.Password(c, u, request.Password, "passkey_registration") {
+		return
+	}
+
+	options, sessionID, err := h.relyingParty.BeginRegistration(u)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start passkey registration",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// FinishRegistration verifies the credential created by the browser and stores it
+func (h *Handler) FinishRegistration(c *gin.Context) {
+	var request RegistrationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid passkey registration: " + err.Error(),
+		})
+		return
+	}
+
+	u, err := h.userRepo.GetByID(c.GetString("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get user",
+		})
+		return
+	}
+
+	credential, err := h.relyingParty.FinishRegistration(u, request.SessionID, request.Name, request.Credential)
+	if err != nil {
+		switch err {
+		case ErrSessionNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Registration expired, please start again",
+			})
+		case ErrInvalidCredential:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Passkey could not be verified",
+			})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to register passkey",
+			})
+		}
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"passkey_id": credential.ID,
+			"name":       credential.Name,
+		}
+		h.auditLogger.LogFromGin(c, "PASSKEY_REGISTERED", "user", u.ID, details)
+	}
+
+	go func() {
+		if err := h.sendPasskeyAddedEmail(context.Background(), u, credential); err != nil {
+			log.Printf("Failed to send passkey notification to user %s: %v", u.ID, err)
+		}
+	}()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Passkey registered successfully",
+		"passkey": credential,
+	})
+}
+
+// sendPasskeyAddedEmail tells a user a passkey was added to their account, in case it was not them
+func (h *Handler) sendPasskeyAddedEmail(ctx context.Context, u *user.User, credential *Credential) error {
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      u.Email,
+		Subject: "A passkey was added to your account",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nA passkey named %q was added to your account on %s. It can be used to sign in without your password.\n\nIf this was not you, remove it from your account settings and change your password.\n",
+			u.Username, credential.Name, credential.CreatedAt.UTC().Format("2 January 2006 15:04 MST"),
+		),
+	})
+}
+
+// GetPasskeys lists the passkeys of the current user
+func (h *Handler) GetPasskeys(c *gin.Context) {
+	credentials, err := h.passkeyRepo.GetByUser(c.GetString("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get passkeys",
+		})
+		return
+	}
+
+	if credentials == nil {
+		credentials = []Credential{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"passkeys": credentials,
+	})
+}
+
+// DeletePasskey removes one of the passkeys of the current user
+func (h *Handler) DeletePasskey(c *gin.Context) {
+	userID := c.GetString("userID")
+	passkeyID := c.Param("passkeyId")
+
+	if err := h.passkeyRepo.Delete(userID, passkeyID); err != nil {
+		if err == ErrCredentialNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Passkey not found",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete passkey",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"passkey_id": passkeyID,
+		}
+		h.auditLogger.LogFromGin(c, "PASSKEY_REMOVED", "user", userID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Passkey removed successfully",
+	})
+}
@@ -0,0 +1,79 @@
+package passkey
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthn credentials table in the database
+// Record is the credential record go-webauthn verifies assertions against
+type Credential struct {
+	ID         string              `json:"id"`
+	UserID     string              `json:"user_id"`
+	Name       string              `json:"name"`
+	Record     webauthn.Credential `json:"-"`
+	LastUsedAt *time.Time          `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+}
+
+// For input validation when starting a registration ceremony
+// The current password is asked again, so a stolen access token alone cannot add a passkey
+type RegistrationBeginRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// For input validation when finishing a registration ceremony
+// Credential is the PublicKeyCredential returned by navigator.credentials.create()
+type RegistrationRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Name       string          `json:"name" binding:"max=100"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+// For input validation when finishing an assertion ceremony
+// Credential is the PublicKeyCredential returned by navigator.credentials.get()
+type AssertionRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+// webauthnUser adapts a user and their credentials to the webauthn.User interface
+// The user handle is the user ID, which lets passkey logins find the user without a username
+type webauthnUser struct {
+	user        *user.User
+	credentials []webauthn.Credential
+}
+
+func newWebauthnUser(u *user.User, credentials []Credential) *webauthnUser {
+	records := make([]webauthn.Credential, len(credentials))
+	for i := range credentials {
+		records[i] = credentials[i].Record
+	}
+
+	return &webauthnUser{
+		user:        u,
+		credentials: records,
+	}
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	if name := u.user.FirstName + " " + u.user.LastName; name != " " {
+		return name
+	}
+	return u.user.Username
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
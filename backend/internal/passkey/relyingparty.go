@@ -0,0 +1,277 @@
+package passkey
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ceremonyTimeout is how long the browser has to answer a registration or assertion challenge
+const ceremonyTimeout = 5 * time.Minute
+
+var (
+	ErrInvalidCredential = errors.New("passkey could not be verified")
+	ErrNoCredentials     = errors.New("no passkey registered")
+	ErrCloneWarning      = errors.New("passkey signature counter went backwards, the authenticator may be cloned")
+)
+
+// credentialStore keeps credentials and the state of ceremonies, it is implemented by Repository
+type credentialStore interface {
+	Create(userID, name string, record *webauthn.Credential) (*Credential, error)
+	GetByUser(userID string) ([]Credential, error)
+	HasCredentials(userID string) (bool, error)
+	RecordUse(record *webauthn.Credential) error
+	DeleteByUser(userID string) (int64, error)
+	SaveSession(userID string, session *webauthn.SessionData) (string, error)
+	ConsumeSession(id, userID string) (*webauthn.SessionData, error)
+}
+
+// userLookup finds the owner of a passkey, it is implemented by user.Repository
+type userLookup interface {
+	GetByID(id string) (*user.User, error)
+}
+
+// RelyingParty runs the WebAuthn registration and assertion ceremonies
+// The state of each ceremony is kept in the database between the begin and finish calls
+type RelyingParty struct {
+	webauthn    *webauthn.WebAuthn
+	passkeyRepo credentialStore
+	userRepo    userLookup
+}
+
+func NewRelyingParty(cfg *config.Config, passkeyRepo *Repository, userRepo *user.Repository) (*RelyingParty, error) {
+	return newRelyingParty(cfg, passkeyRepo, userRepo)
+}
+
+func newRelyingParty(cfg *config.Config, passkeyRepo credentialStore, userRepo userLookup) (*RelyingParty, error) {
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    ceremonyTimeout,
+		TimeoutUVD: ceremonyTimeout,
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelyingParty{
+		webauthn:    w,
+		passkeyRepo: passkeyRepo,
+		userRepo:    userRepo,
+	}, nil
+}
+
+// HasCredentials reports whether a user can sign in with a passkey
+func (rp *RelyingParty) HasCredentials(userID string) (bool, error) {
+	return rp.passkeyRepo.HasCredentials(userID)
+}
+
+// RemoveCredentials deletes every passkey of a user and returns how many there were
+func (rp *RelyingParty) RemoveCredentials(userID string) (int64, error) {
+	return rp.passkeyRepo.DeleteByUser(userID)
+}
+
+// BeginRegistration creates the options for navigator.credentials.create()
+// Credentials the user already has are excluded so the same authenticator is not registered twice
+func (rp *RelyingParty) BeginRegistration(u *user.User) (*protocol.CredentialCreation, string, error) {
+	wu, err := rp.loadUser(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creation, session, err := rp.webauthn.BeginRegistration(wu,
+		webauthn.WithExclusions(webauthn.Credentials(wu.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := rp.passkeyRepo.SaveSession(u.ID, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return creation, sessionID, nil
+}
+
+// FinishRegistration verifies the attestation returned by the browser and stores the new credential
+func (rp *RelyingParty) FinishRegistration(u *user.User, sessionID, name string, response []byte) (*Credential, error) {
+	session, err := rp.passkeyRepo.ConsumeSession(sessionID, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	wu, err := rp.loadUser(u)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := rp.webauthn.CreateCredential(wu, *session, parsed)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+
+	return rp.passkeyRepo.Create(u.ID, name, record)
+}
+
+// BeginLogin creates the options for navigator.credentials.get() restricted to the credentials of a user
+// It is used when a passkey is the second factor after a password
+func (rp *RelyingParty) BeginLogin(u *user.User) (*protocol.CredentialAssertion, string, error) {
+	wu, err := rp.loadUser(u)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(wu.credentials) == 0 {
+		return nil, "", ErrNoCredentials
+	}
+
+	assertion, session, err := rp.webauthn.BeginLogin(wu)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := rp.passkeyRepo.SaveSession(u.ID, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assertion, sessionID, nil
+}
+
+// FinishLogin verifies an assertion made with one of the credentials of a user
+func (rp *RelyingParty) FinishLogin(u *user.User, sessionID string, response []byte) (*Credential, error) {
+	session, err := rp.passkeyRepo.ConsumeSession(sessionID, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	wu, err := rp.loadUser(u)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := rp.webauthn.ValidateLogin(wu, *session, parsed)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	return rp.recordUse(wu, record)
+}
+
+// BeginDiscoverableLogin creates the options for a passkey login where the user is not known yet
+// The authenticator must verify the user, since the passkey replaces both the password and the second factor
+func (rp *RelyingParty) BeginDiscoverableLogin() (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := rp.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := rp.passkeyRepo.SaveSession("", session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assertion, sessionID, nil
+}
+
+// FinishDiscoverableLogin verifies a passkey assertion and returns the user it belongs to
+// The user is found through the user handle the authenticator stored at registration
+func (rp *RelyingParty) FinishDiscoverableLogin(sessionID string, response []byte) (*user.User, *Credential, error) {
+	session, err := rp.passkeyRepo.ConsumeSession(sessionID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, nil, ErrInvalidCredential
+	}
+
+	var owner *webauthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		u, err := rp.userRepo.GetByID(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		if owner, err = rp.loadUser(u); err != nil {
+			return nil, err
+		}
+		return owner, nil
+	}
+
+	_, record, err := rp.webauthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil {
+		return nil, nil, ErrInvalidCredential
+	}
+
+	credential, err := rp.recordUse(owner, record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return owner.user, credential, nil
+}
+
+// recordUse stores the new signature counter of a credential after a successful assertion
+// Assertions from a credential whose counter went backwards are rejected
+func (rp *RelyingParty) recordUse(wu *webauthnUser, record *webauthn.Credential) (*Credential, error) {
+	if record.Authenticator.CloneWarning {
+		return nil, ErrCloneWarning
+	}
+
+	if err := rp.passkeyRepo.RecordUse(record); err != nil {
+		return nil, err
+	}
+
+	credentials, err := rp.passkeyRepo.GetByUser(wu.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range credentials {
+		if bytes.Equal(credentials[i].Record.ID, record.ID) {
+			return &credentials[i], nil
+		}
+	}
+
+	return nil, ErrCredentialNotFound
+}
+
+func (rp *RelyingParty) loadUser(u *user.User) (*webauthnUser, error) {
+	credentials, err := rp.passkeyRepo.GetByUser(u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWebauthnUser(u, credentials), nil
+}
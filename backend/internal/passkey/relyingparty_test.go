@@ -0,0 +1,427 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// memoryStore keeps credentials and ceremonies in memory, with the same rules as Repository
+type memoryStore struct {
+	credentials []Credential
+	sessions    map[string]memorySession
+	nextID      int
+}
+
+type memorySession struct {
+	userID string
+	data   webauthn.SessionData
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{sessions: map[string]memorySession{}}
+}
+
+func (s *memoryStore) id() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func (s *memoryStore) Create(userID, name string, record *webauthn.Credential) (*Credential, error) {
+	credential := Credential{ID: s.id(), UserID: userID, Name: name, Record: *record, CreatedAt: time.Now()}
+	s.credentials = append(s.credentials, credential)
+	return &credential, nil
+}
+
+func (s *memoryStore) GetByUser(userID string) ([]Credential, error) {
+	var credentials []Credential
+	for _, credential := range s.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (s *memoryStore) HasCredentials(userID string) (bool, error) {
+	credentials, _ := s.GetByUser(userID)
+	return len(credentials) > 0, nil
+}
+
+func (s *memoryStore) RecordUse(record *webauthn.Credential) error {
+	for i := range s.credentials {
+		if bytes.Equal(s.credentials[i].Record.ID, record.ID) {
+			now := time.Now()
+			s.credentials[i].Record = *record
+			s.credentials[i].LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteByUser(userID string) (int64, error) {
+	var kept []Credential
+	for _, credential := range s.credentials {
+		if credential.UserID != userID {
+			kept = append(kept, credential)
+		}
+	}
+	removed := int64(len(s.credentials) - len(kept))
+	s.credentials = kept
+	return removed, nil
+}
+
+func (s *memoryStore) SaveSession(userID string, session *webauthn.SessionData) (string, error) {
+	id := s.id()
+	s.sessions[id] = memorySession{userID: userID, data: *session}
+	return id, nil
+}
+
+func (s *memoryStore) ConsumeSession(id, userID string) (*webauthn.SessionData, error) {
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	if !ok || session.userID != userID || time.Now().After(session.data.Expires) {
+		return nil, ErrSessionNotFound
+	}
+	return &session.data, nil
+}
+
+type memoryUsers map[string]*user.User
+
+func (u memoryUsers) GetByID(id string) (*user.User, error) {
+	if found, ok := u[id]; ok {
+		return found, nil
+	}
+	return nil, user.ErrUserNotFound
+}
+
+// softAuthenticator is a platform authenticator in software that answers ceremonies with a P-256 key
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func clientData(t *testing.T, ceremony protocol.CeremonyType, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(protocol.CollectedClientData{
+		Type:      ceremony,
+		Challenge: encode(challenge),
+		Origin:    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authenticatorData builds the authenticator data with user presence and verification set
+func (a *softAuthenticator) authenticatorData(attested []byte) []byte {
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested != nil {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// create answers navigator.credentials.create() with a "none" attestation
+func (a *softAuthenticator) create(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	t.Helper()
+
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.respond(t, map[string]interface{}{
+		"clientDataJSON":    encode(clientData(t, protocol.CreateCeremony, creation.Response.Challenge)),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// get answers navigator.credentials.get() with a signed assertion
+func (a *softAuthenticator) get(t *testing.T, assertion *protocol.CredentialAssertion) []byte {
+	t.Helper()
+
+	a.signCount++
+	authData := a.authenticatorData(nil)
+	data := clientData(t, protocol.AssertCeremony, assertion.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(data)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.respond(t, map[string]interface{}{
+		"clientDataJSON":    encode(data),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) respond(t *testing.T, response map[string]interface{}) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func newTestRelyingParty(t *testing.T, users memoryUsers) (*RelyingParty, *memoryStore) {
+	t.Helper()
+
+	store := newMemoryStore()
+	rp, err := newRelyingParty(&config.Config{
+		WebAuthnRPID:    testRPID,
+		WebAuthnRPName:  "Auth Service",
+		WebAuthnOrigins: []string{testOrigin},
+	}, store, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp, store
+}
+
+func register(t *testing.T, rp *RelyingParty, u *user.User, a *softAuthenticator) *Credential {
+	t.Helper()
+
+	creation, sessionID, err := rp.BeginRegistration(u)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+
+	credential, err := rp.FinishRegistration(u, sessionID, "Laptop", a.create(t, creation))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return credential
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	alice := &user.User{ID: "1", Username: "alice"}
+	rp, store := newTestRelyingParty(t, memoryUsers{alice.ID: alice})
+	a := newSoftAuthenticator(t)
+
+	credential := register(t, rp, alice, a)
+	if credential.UserID != alice.ID || credential.Name != "Laptop" {
+		t.Fatalf("registered %+v", credential)
+	}
+	if has, _ := rp.HasCredentials(alice.ID); !has {
+		t.Fatal("HasCredentials is false after registration")
+	}
+
+	assertion, sessionID, err := rp.BeginLogin(alice)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	used, err := rp.FinishLogin(alice, sessionID, a.get(t, assertion))
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if used.ID != credential.ID || used.LastUsedAt == nil {
+		t.Fatalf("used %+v", used)
+	}
+
+	assertion, sessionID, err = rp.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatalf("BeginDiscoverableLogin: %v", err)
+	}
+	owner, _, err := rp.FinishDiscoverableLogin(sessionID, a.get(t, assertion))
+	if err != nil {
+		t.Fatalf("FinishDiscoverableLogin: %v", err)
+	}
+	if owner.ID != alice.ID {
+		t.Fatalf("passkey login found user %s, want %s", owner.ID, alice.ID)
+	}
+
+	if got := store.credentials[0].Record.Authenticator.SignCount; got != a.signCount {
+		t.Fatalf("stored sign count %d, want %d", got, a.signCount)
+	}
+}
+
+func TestFinishRegistrationRejectsSessionOfAnotherUser(t *testing.T) {
+	alice := &user.User{ID: "1", Username: "alice"}
+	mallory := &user.User{ID: "2", Username: "mallory"}
+	rp, _ := newTestRelyingParty(t, memoryUsers{alice.ID: alice, mallory.ID: mallory})
+	a := newSoftAuthenticator(t)
+
+	creation, sessionID, err := rp.BeginRegistration(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rp.FinishRegistration(mallory, sessionID, "", a.create(t, creation)); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("FinishRegistration with another user's session: %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestFinishLoginRejectsReplayedChallenge(t *testing.T) {
+	alice := &user.User{ID: "1", Username: "alice"}
+	rp, _ := newTestRelyingParty(t, memoryUsers{alice.ID: alice})
+	a := newSoftAuthenticator(t)
+	register(t, rp, alice, a)
+
+	assertion, sessionID, err := rp.BeginLogin(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := a.get(t, assertion)
+	if _, err := rp.FinishLogin(alice, sessionID, response); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rp.FinishLogin(alice, sessionID, response); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("replayed assertion: %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestFinishLoginRejectsOtherKey(t *testing.T) {
+	alice := &user.User{ID: "1", Username: "alice"}
+	rp, _ := newTestRelyingParty(t, memoryUsers{alice.ID: alice})
+	a := newSoftAuthenticator(t)
+	register(t, rp, alice, a)
+
+	// Same credential ID, different key, as a forged authenticator would present
+	forged := newSoftAuthenticator(t)
+	forged.credentialID, forged.userHandle = a.credentialID, a.userHandle
+
+	assertion, sessionID, err := rp.BeginLogin(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.FinishLogin(alice, sessionID, forged.get(t, assertion)); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("forged assertion: %v, want ErrInvalidCredential", err)
+	}
+}
+
+func TestFinishLoginRejectsClonedAuthenticator(t *testing.T) {
+	alice := &user.User{ID: "1", Username: "alice"}
+	rp, _ := newTestRelyingParty(t, memoryUsers{alice.ID: alice})
+	a := newSoftAuthenticator(t)
+	register(t, rp, alice, a)
+
+	for i := 0; i < 2; i++ {
+		assertion, sessionID, err := rp.BeginLogin(alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.FinishLogin(alice, sessionID, a.get(t, assertion)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clone := *a
+	clone.signCount = 0
+
+	assertion, sessionID, err := rp.BeginLogin(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.FinishLogin(alice, sessionID, clone.get(t, assertion)); !errors.Is(err, ErrCloneWarning) {
+		t.Fatalf("assertion with a lower sign count: %v, want ErrCloneWarning", err)
+	}
+}
+
+func TestRemoveCredentials(t *testing.T) {
+	alice := &user.User{ID: "1", Username: "alice"}
+	bob := &user.User{ID: "2", Username: "bob"}
+	rp, _ := newTestRelyingParty(t, memoryUsers{alice.ID: alice, bob.ID: bob})
+	register(t, rp, alice, newSoftAuthenticator(t))
+	register(t, rp, alice, newSoftAuthenticator(t))
+	register(t, rp, bob, newSoftAuthenticator(t))
+
+	removed, err := rp.RemoveCredentials(alice.ID)
+	if err != nil || removed != 2 {
+		t.Fatalf("RemoveCredentials = %d, %v, want 2", removed, err)
+	}
+	if has, _ := rp.HasCredentials(alice.ID); has {
+		t.Fatal("alice still has passkeys")
+	}
+	if has, _ := rp.HasCredentials(bob.ID); !has {
+		t.Fatal("bob's passkey was removed too")
+	}
+
+	if _, _, err := rp.BeginLogin(alice); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("BeginLogin without passkeys: %v, want ErrNoCredentials", err)
+	}
+}
@@ -0,0 +1,212 @@
+package passkey
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var (
+	ErrCredentialNotFound = errors.New("passkey not found")
+	ErrSessionNotFound    = errors.New("webauthn session not found or expired")
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		db: database.DB,
+	}
+}
+
+// Create stores a newly registered credential
+func (r *Repository) Create(userID, name string, record *webauthn.Credential) (*Credential, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &Credential{
+		UserID:    userID,
+		Name:      name,
+		Record:    *record,
+		CreatedAt: time.Now(),
+	}
+
+	query := `
+		INSERT INTO webauthn_credentials (user_id, credential_id, name, credential, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err = r.db.QueryRow(query, userID, record.ID, name, data, credential.CreatedAt).Scan(&credential.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// GetByUser returns the credentials of a user
+func (r *Repository) GetByUser(userID string) ([]Credential, error) {
+	query := `
+		SELECT id, user_id, name, credential, last_used_at, created_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []Credential
+	for rows.Next() {
+		var credential Credential
+		var data []byte
+		if err := rows.Scan(
+			&credential.ID,
+			&credential.UserID,
+			&credential.Name,
+			&data,
+			&credential.LastUsedAt,
+			&credential.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &credential.Record); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// HasCredentials reports whether a user registered any passkey
+func (r *Repository) HasCredentials(userID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM webauthn_credentials WHERE user_id = $1)`
+	err := r.db.QueryRow(query, userID).Scan(&exists)
+	return exists, err
+}
+
+// RecordUse stores the sign count and flags of a credential after an assertion
+func (r *Repository) RecordUse(record *webauthn.Credential) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE webauthn_credentials
+		SET credential = $2, last_used_at = $3
+		WHERE credential_id = $1
+	`
+
+	_, err = r.db.Exec(query, record.ID, data, time.Now())
+	return err
+}
+
+// Delete removes a credential of a user
+func (r *Repository) Delete(userID, id string) error {
+	result, err := r.db.Exec(`DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrCredentialNotFound
+	}
+
+	return nil
+}
+
+// DeleteByUser removes every credential of a user and returns how many there were
+func (r *Repository) DeleteByUser(userID string) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM webauthn_credentials WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// SaveSession stores the state of a ceremony until the browser answers, and returns its ID
+// userID is empty for passkey logins, where the user is not known yet
+func (r *Repository) SaveSession(userID string, session *webauthn.SessionData) (string, error) {
+	id, err := token.RandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	var owner sql.NullString
+	if userID != "" {
+		owner = sql.NullString{String: userID, Valid: true}
+	}
+
+	query := `
+		INSERT INTO webauthn_sessions (id, user_id, data, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = r.db.Exec(query, token.HashToken(id), owner, data, session.Expires, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ConsumeSession returns the state of a ceremony and deletes it, so each challenge is answered only once
+// It returns ErrSessionNotFound for unknown or expired sessions and sessions of another user
+func (r *Repository) ConsumeSession(id, userID string) (*webauthn.SessionData, error) {
+	var data []byte
+	var owner sql.NullString
+	var expiresAt time.Time
+
+	query := `
+		DELETE FROM webauthn_sessions
+		WHERE id = $1
+		RETURNING user_id, data, expires_at
+	`
+
+	err := r.db.QueryRow(query, token.HashToken(id)).Scan(&owner, &data, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	if owner.String != userID || time.Now().After(expiresAt) {
+		return nil, ErrSessionNotFound
+	}
+
+	session := &webauthn.SessionData{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
@@ -0,0 +1,85 @@
+package reauth
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bharabhi01/authservice/internal/user"
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/bharabhi01/authservice/pkg/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+// Verifier asks a signed in user for their password again before a sensitive account change
+// Wrong passwords count as failed logins, so a stolen access token cannot be used to guess the password
+type Verifier struct {
+	userRepo    *user.Repository
+	limiter     *throttle.Limiter
+	auditLogger *audit.Logger
+}
+
+func NewVerifier(userRepo *user.Repository, limiter *throttle.Limiter, auditLogger *audit.Logger) *Verifier {
+	return &Verifier{
+		userRepo:    userRepo,
+		limiter:     limiter,
+		auditLogger: auditLogger,
+	}
+}
+
+// Password checks password against the current password of u before the change named by action
+// It returns false when the request was answered, because the password is wrong or attempts are held back
+func (v *Verifier) Password(c *gin.Context, u *user.User, password, action string) bool {
+	attempt, err := v.limiter.Attempt(c.Request.Context(), u.Username, c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check login attempts",
+		})
+		return false
+	}
+	if !attempt.Allowed() {
+		// Attempts held back still count, so one of them may be the one that locks the account
+		if attempt.LocksAccount && v.auditLogger != nil {
+			v.auditAccountLocked(c, u)
+		}
+		c.Header("Retry-After", strconv.Itoa(attempt.RetryAfterSeconds()))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Too many wrong passwords, try again later",
+			"retry_after": attempt.RetryAfterSeconds(),
+		})
+		return false
+	}
+
+	if v.userRepo.VerifyPassword(u, password) {
+		if err := v.limiter.Release(c.Request.Context(), u.Username, c.ClientIP()); err != nil {
+			log.Printf("Failed to release password attempt for %s: %v", u.Username, err)
+		}
+		return true
+	}
+
+	if v.auditLogger != nil {
+		details := map[string]interface{}{
+			"action": action,
+			"reason": "wrong current password",
+		}
+		v.auditLogger.LogFromGin(c, "REAUTHENTICATION_FAILED", "user", u.ID, details)
+
+		if attempt.LocksAccount {
+			v.auditAccountLocked(c, u)
+		}
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error": "Current password is incorrect",
+	})
+	return false
+}
+
+// auditAccountLocked records that the attempt just counted locked the account of u
+func (v *Verifier) auditAccountLocked(c *gin.Context, u *user.User) {
+	details := map[string]interface{}{
+		"username": u.Username,
+	}
+	v.auditLogger.LogFromGin(c, "ACCOUNT_LOCKED", "user", u.ID, details)
+}
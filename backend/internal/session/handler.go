@@ -0,0 +1,145 @@
+package session
+
+import (
+	"net/http"
+
+	"github.com/bharabhi01/authservice/internal/token"
+	"github.com/bharabhi01/authservice/pkg/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler handles session listing and revocation requests
+type Handler struct {
+	sessionRepo *Repository
+	tokenRepo   *token.Repository
+	auditLogger *audit.Logger
+}
+
+func NewHandler(sessionRepo *Repository, tokenRepo *token.Repository, auditLogger *audit.Logger) *Handler {
+	return &Handler{
+		sessionRepo: sessionRepo,
+		tokenRepo:   tokenRepo,
+		auditLogger: auditLogger,
+	}
+}
+
+// GetMySessions lists the active sessions of the current user
+func (h *Handler) GetMySessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	h.listSessions(c, userID.(string))
+}
+
+// RevokeMySession ends one of the current user's sessions
+func (h *Handler) RevokeMySession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Not authenticated",
+		})
+		return
+	}
+
+	h.revokeSession(c, userID.(string), c.Param("sid"))
+}
+
+// GetUserSessions lists the active sessions of any user
+// This endpoint is meant for admins
+func (h *Handler) GetUserSessions(c *gin.Context) {
+	userID := c.Param("id")
+	if !validID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	h.listSessions(c, userID)
+}
+
+// RevokeUserSession ends a session of any user
+// This endpoint is meant for admins
+func (h *Handler) RevokeUserSession(c *gin.Context) {
+	userID := c.Param("id")
+	if !validID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	h.revokeSession(c, userID, c.Param("sid"))
+}
+
+func (h *Handler) listSessions(c *gin.Context, userID string) {
+	sessions, err := h.sessionRepo.GetByUser(userID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get sessions",
+		})
+		return
+	}
+
+	currentID := c.GetString("sessionID")
+
+	response := []SessionResponse{}
+	for _, s := range sessions {
+		response = append(response, SessionResponse{
+			Session: s,
+			Current: s.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": response,
+	})
+}
+
+func (h *Handler) revokeSession(c *gin.Context, userID, sessionID string) {
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Session ID is required",
+		})
+		return
+	}
+
+	if err := h.sessionRepo.Revoke(userID, sessionID); err != nil {
+		if err == ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Session not found",
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke session",
+		})
+		return
+	}
+
+	if err := h.tokenRepo.RevokeSession(sessionID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke session tokens",
+		})
+		return
+	}
+
+	if h.auditLogger != nil {
+		details := map[string]interface{}{
+			"session_id": sessionID,
+		}
+		h.auditLogger.LogFromGin(c, "SESSION_REVOKED", "user", userID, details)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked successfully",
+	})
+}
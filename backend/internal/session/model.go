@@ -0,0 +1,23 @@
+package session
+
+import (
+	"time"
+)
+
+// Sessions table in the database
+// A session is created for every login and ends when it is revoked
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Adds whether the session is the one making the request
+type SessionResponse struct {
+	Session
+	Current bool `json:"current"`
+}
@@ -0,0 +1,238 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bharabhi01/authservice/pkg/database"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// last_seen_at is only written when it is older than this, so busy sessions do not write on every request
+const touchInterval = time.Minute
+
+// Repository stores sessions
+// A session unused for longer than idleTimeout has expired, like the refresh tokens issued to it
+type Repository struct {
+	db          *sql.DB
+	idleTimeout time.Duration
+}
+
+func NewRepository(idleTimeout time.Duration) *Repository {
+	return &Repository{
+		db:          database.DB,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// validID reports whether id can be a session ID at all, so malformed IDs are not sent to the database
+func validID(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 32)
+	return err == nil
+}
+
+// Create starts a new session for a user
+func (r *Repository) Create(userID, userAgent, ipAddress string) (*Session, error) {
+	now := time.Now()
+
+	s := &Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastSeenAt: now,
+		CreatedAt:  now,
+	}
+
+	query := `
+		INSERT INTO sessions (user_id, user_agent, ip_address, last_seen_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := r.db.QueryRow(
+		query,
+		s.UserID,
+		s.UserAgent,
+		s.IPAddress,
+		s.LastSeenAt,
+		s.CreatedAt,
+	).Scan(&s.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetByUser returns the sessions of a user that have neither been revoked nor expired, most recently used first
+func (r *Repository) GetByUser(userID string) ([]Session, error) {
+	query := `
+		SELECT id, user_id, user_agent, ip_address, last_seen_at, revoked_at, created_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND last_seen_at > $2
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID, time.Now().Add(-r.idleTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.UserAgent,
+			&s.IPAddress,
+			&s.LastSeenAt,
+			&s.RevokedAt,
+			&s.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Validate reports whether a session is still active and records that it was just used
+// Sessions that were revoked, have expired or never existed are not active
+func (r *Repository) Validate(id string) (bool, error) {
+	if !validID(id) {
+		return false, nil
+	}
+
+	now := time.Now()
+	expired := now.Add(-r.idleTimeout)
+
+	query := `
+		UPDATE sessions
+		SET last_seen_at = $2
+		WHERE id = $1 AND revoked_at IS NULL AND last_seen_at < $3 AND last_seen_at > $4
+	`
+
+	result, err := r.db.Exec(query, id, now, now.Add(-touchInterval), expired)
+	if err != nil {
+		return false, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if affected > 0 {
+		return true, nil
+	}
+
+	// Nothing was updated, either the session was seen recently or it is gone
+	var active bool
+	err = r.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND last_seen_at > $2
+		)
+	`, id, expired).Scan(&active)
+
+	return active, err
+}
+
+// Revoke ends a session of a user
+// It returns ErrSessionNotFound if the session does not belong to the user or was already revoked
+func (r *Repository) Revoke(userID, id string) error {
+	if !validID(id) {
+		return ErrSessionNotFound
+	}
+
+	query := `
+		UPDATE sessions
+		SET revoked_at = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeByID ends a session regardless of its owner
+func (r *Repository) RevokeByID(id string) error {
+	if !validID(id) {
+		return nil
+	}
+
+	query := `
+		UPDATE sessions
+		SET revoked_at = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, id, time.Now())
+	return err
+}
+
+// RevokeAllForUser ends every session of a user
+func (r *Repository) RevokeAllForUser(userID string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, userID, time.Now())
+	return err
+}
+
+// Prune deletes sessions that expired or were revoked more than idleTimeout ago, with their refresh tokens
+// Access tokens of a deleted session are rejected like those of a revoked one
+func (r *Repository) Prune() (int64, error) {
+	before := time.Now().Add(-r.idleTimeout)
+
+	result, err := r.db.Exec(`DELETE FROM sessions WHERE last_seen_at < $1 OR revoked_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartPruning prunes sessions every interval until stop is closed
+func (r *Repository) StartPruning(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pruned, err := r.Prune()
+				if err != nil {
+					log.Printf("Failed to prune sessions: %v", err)
+					continue
+				}
+				if pruned > 0 {
+					log.Printf("Pruned %d expired sessions", pruned)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package token
+
+import (
+	"time"
+)
+
+// Refresh tokens table in the database
+// Token holds the SHA-256 hash of the opaque value handed to the client.
+// ClientID, Scope and Audience are carried over to every access token minted from it.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Token     string     `json:"-"`
+	FamilyID  string     `json:"family_id"`
+	SessionID string     `json:"session_id,omitempty"`
+	ClientID  string     `json:"client_id,omitempty"`
+	Scope     string     `json:"scope,omitempty"`
+	Audience  []string   `json:"audience,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// For input validation when exchanging a refresh token
+// In cookie mode the refresh token may come from its cookie instead
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// For logout, the refresh token is optional
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// IsActive reports whether the token can still be exchanged
+// A token that was already rotated or revoked is no longer active
+func (t *RefreshToken) IsActive() bool {
+	return t.RotatedAt == nil && t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
@@ -0,0 +1,249 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/bharabhi01/authservice/pkg/database"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrTokenNotFound is returned when no refresh token matches the presented value
+	ErrTokenNotFound = errors.New("refresh token not found")
+	// ErrTokenExpired is returned when the refresh token is past its expiry
+	ErrTokenExpired = errors.New("refresh token expired")
+	// ErrTokenReused is returned when an already rotated or revoked token is presented again
+	// The whole token family has been revoked by the time this error is returned
+	ErrTokenReused = errors.New("refresh token reuse detected")
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		db: database.DB,
+	}
+}
+
+// Create issues a new refresh token
+// The caller fills in the user, client, scope, audience and expiry; an empty
+// FamilyID starts a new token family (i.e. a new login).
+// It returns the plaintext token, which is only available at this point
+func (r *Repository) Create(t *RefreshToken) (string, error) {
+	return r.create(r.db, t)
+}
+
+// Rotate exchanges a refresh token for a new one in the same family
+// Presenting a token that was already rotated or revoked revokes the whole family
+func (r *Repository) Rotate(plaintext string, expiresAt time.Time) (string, *RefreshToken, error) {
+	current, err := r.GetByToken(plaintext)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if current.RotatedAt != nil || current.RevokedAt != nil {
+		if err := r.RevokeFamily(current.FamilyID); err != nil {
+			return "", nil, err
+		}
+		return "", current, ErrTokenReused
+	}
+
+	if !time.Now().Before(current.ExpiresAt) {
+		return "", current, ErrTokenExpired
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	// Only one concurrent exchange can win the update below, any other caller is treated as reuse
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET rotated_at = $2
+		WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL
+	`, current.ID, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return "", nil, err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		if err := r.RevokeFamily(current.FamilyID); err != nil {
+			return "", nil, err
+		}
+		return "", current, ErrTokenReused
+	}
+
+	next := &RefreshToken{
+		UserID:    current.UserID,
+		FamilyID:  current.FamilyID,
+		SessionID: current.SessionID,
+		ClientID:  current.ClientID,
+		Scope:     current.Scope,
+		Audience:  current.Audience,
+		ExpiresAt: expiresAt,
+	}
+	newPlaintext, err := r.create(tx, next)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+
+	return newPlaintext, next, nil
+}
+
+// GetByToken looks up a refresh token by its plaintext value
+func (r *Repository) GetByToken(plaintext string) (*RefreshToken, error) {
+	t := &RefreshToken{}
+	var sessionID sql.NullString
+
+	query := `
+		SELECT id, user_id, token, family_id, session_id, client_id, scope, audience, expires_at, rotated_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token = $1
+	`
+
+	err := r.db.QueryRow(query, HashToken(plaintext)).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Token,
+		&t.FamilyID,
+		&sessionID,
+		&t.ClientID,
+		&t.Scope,
+		pq.Array(&t.Audience),
+		&t.ExpiresAt,
+		&t.RotatedAt,
+		&t.RevokedAt,
+		&t.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	t.SessionID = sessionID.String
+
+	return t, nil
+}
+
+// RevokeFamily revokes every token that descends from the same login
+func (r *Repository) RevokeFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, familyID, time.Now())
+	return err
+}
+
+// RevokeSession revokes every refresh token issued within a session
+func (r *Repository) RevokeSession(sessionID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE session_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, sessionID, time.Now())
+	return err
+}
+
+// RevokeAllForUser revokes every outstanding refresh token of a user
+func (r *Repository) RevokeAllForUser(userID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, userID, time.Now())
+	return err
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (r *Repository) create(db execer, t *RefreshToken) (string, error) {
+	plaintext, err := RandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	if t.FamilyID == "" {
+		t.FamilyID, err = RandomString(16)
+		if err != nil {
+			return "", err
+		}
+	}
+	if t.Audience == nil {
+		t.Audience = []string{}
+	}
+
+	t.Token = HashToken(plaintext)
+	t.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, token, family_id, session_id, client_id, scope, audience, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+
+	err = db.QueryRow(
+		query,
+		t.UserID,
+		t.Token,
+		t.FamilyID,
+		sql.NullString{String: t.SessionID, Valid: t.SessionID != ""},
+		t.ClientID,
+		t.Scope,
+		pq.Array(t.Audience),
+		t.ExpiresAt,
+		t.CreatedAt,
+	).Scan(&t.ID)
+
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest stored in place of the token
+func HashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomString returns n random bytes encoded as unpadded base64url
+// It is used for every opaque value this service hands out
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
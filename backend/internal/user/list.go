@@ -0,0 +1,179 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Columns the user list can be sorted by
+var sortColumns = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+const defaultListLimit = 20
+
+// cursor points after the last user of a page, by its sort value and ID
+type cursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// List returns a page of users matching the filter and the cursor of the next page
+// Pages are keyed on the sort column and the ID, so they stay stable while users are added or removed
+// The next cursor is empty on the last page
+func (r *Repository) List(filter *ListFilter) ([]User, string, error) {
+	sortColumn, ok := sortColumns[filter.Sort]
+	if !ok {
+		sortColumn = "id"
+	}
+
+	order, comparison := "ASC", ">"
+	if filter.Order == "desc" {
+		order, comparison = "DESC", "<"
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE 1=1
+	`
+
+	args := []interface{}{}
+	argCount := 1
+
+	if filter.Username != "" {
+		query += ` AND username ILIKE $` + strconv.Itoa(argCount)
+		args = append(args, "%"+filter.Username+"%")
+		argCount++
+	}
+
+	if filter.Email != "" {
+		query += ` AND email ILIKE $` + strconv.Itoa(argCount)
+		args = append(args, "%"+filter.Email+"%")
+		argCount++
+	}
+
+	if filter.Role != "" {
+		query += ` AND EXISTS (
+			SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+			WHERE ur.user_id = users.id AND r.name = $` + strconv.Itoa(argCount) + `
+		)`
+		args = append(args, filter.Role)
+		argCount++
+	}
+
+	if filter.Active != nil {
+		query += ` AND active = $` + strconv.Itoa(argCount)
+		args = append(args, *filter.Active)
+		argCount++
+	}
+
+	if filter.Cursor != "" {
+		after, err := decodeCursor(filter.Cursor, sortColumn)
+		if err != nil {
+			return nil, "", err
+		}
+		query += ` AND (` + sortColumn + `, id) ` + comparison + ` ($` + strconv.Itoa(argCount) + `, $` + strconv.Itoa(argCount+1) + `)`
+		args = append(args, after.Value, after.ID)
+		argCount += 2
+	}
+
+	// One extra row tells whether there is a next page
+	query += ` ORDER BY ` + sortColumn + ` ` + order + `, id ` + order + ` LIMIT $` + strconv.Itoa(argCount)
+	args = append(args, limit+1)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, "", err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(users) <= limit {
+		return users, "", nil
+	}
+
+	users = users[:limit]
+	next, err := encodeCursor(sortValue(&users[limit-1], sortColumn), users[limit-1].ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return users, next, nil
+}
+
+// sortValue returns the value of the sort column of a user, in a form Postgres can compare against it
+func sortValue(user *User, column string) string {
+	switch column {
+	case "username":
+		return user.Username
+	case "email":
+		return user.Email
+	case "created_at":
+		return user.CreatedAt.Format("2006-01-02 15:04:05.999999")
+	default:
+		return user.ID
+	}
+}
+
+func encodeCursor(value, id string) (string, error) {
+	data, err := json.Marshal(cursor{Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor reads a cursor made by encodeCursor for the same sort column
+// Values Postgres could not compare against the column are rejected here, instead of failing the query
+func decodeCursor(encoded, column string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := strconv.ParseInt(c.ID, 10, 32); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	switch column {
+	case "id":
+		if _, err := strconv.ParseInt(c.Value, 10, 32); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	case "created_at":
+		if _, err := time.Parse("2006-01-02 15:04:05.999999", c.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &c, nil
+}
@@ -12,25 +12,82 @@ type User struct {
 	PasswordHash string `json:"-"`
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
-	Role string `json:"role"`
+	Roles []string `json:"roles"`
 	Active bool `json:"active"`
+	EmailVerified bool `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // For input validation during registration
 type UserRegistration struct {
-	Username string `json:"username binding:"required, min=3,max=50`
-	Email string `json:"email" binding:"required,email`
-	Password string `json:"password" binding:"required,min=8`
-	FirstName string `json:"first_name`
-	LastName string `json:"last_name`
+	Username string `json:"username" binding:"required,min=3,max=50"`
+	Email string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName string `json:"last_name"`
+}
+
+// For input validation when users update their own profile
+// Fields left out of the request are not changed
+// CurrentPassword is only needed to change the email address
+type ProfileUpdate struct {
+	FirstName *string `json:"first_name" binding:"omitempty,max=100"`
+	LastName *string `json:"last_name" binding:"omitempty,max=100"`
+	Email *string `json:"email" binding:"omitempty,email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// For input validation when users change their password
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// For input validation when admins update a user
+// Roles are managed through the role endpoints and the active flag through activate/deactivate
+type UserUpdate struct {
+	Username *string `json:"username" binding:"omitempty,min=3,max=50"`
+	Email *string `json:"email" binding:"omitempty,email"`
+	FirstName *string `json:"first_name" binding:"omitempty,max=100"`
+	LastName *string `json:"last_name" binding:"omitempty,max=100"`
+	EmailVerified *bool `json:"email_verified"`
+}
+
+// For input validation when admins import users from another system
+// PasswordHash must be in a format the password hasher understands, see password.New
+type UserImport struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+	Email string `json:"email" binding:"required,email"`
+	PasswordHash string `json:"password_hash" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName string `json:"last_name"`
+	EmailVerified bool `json:"email_verified"`
+}
+
+// For input validation of a batch of imported users
+type UserImportRequest struct {
+	Users []UserImport `json:"users" binding:"required,min=1,max=1000,dive"`
+}
+
+// For filtering, sorting and paging the user list
+// Username and email match partially, Role matches users holding that role, Cursor is the next_cursor of the previous page
+type ListFilter struct {
+	Username string `form:"username"`
+	Email string `form:"email"`
+	Role string `form:"role"`
+	Active *bool `form:"active"`
+	Sort string `form:"sort" binding:"omitempty,oneof=id username email created_at"`
+	Order string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Cursor string `form:"cursor"`
+	Limit int `form:"limit" binding:"omitempty,min=1,max=100"`
 }
 
 // For input validation during login
 type UserLogin struct {
-	Username string `json:"username" binding:"required`
-	Password string `json:"password" binding:"required`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
 
 // Omits sensitive information like password hash
@@ -40,7 +97,9 @@ type UserResponse struct {
 	Email string `json:"email"`
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
-	Role string `json:"role"`
+	Roles []string `json:"roles"`
+	Active bool `json:"active"`
+	EmailVerified bool `json:"email_verified"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -52,7 +111,9 @@ func (u *User) ToResponse() UserResponse {
 		Email: u.Email,
 		FirstName: u.FirstName,
 		LastName: u.LastName,
-		Role: u.Role,
+		Roles: u.Roles,
+		Active: u.Active,
+		EmailVerified: u.EmailVerified,
 		CreatedAt: u.CreatedAt,
 	}
 }
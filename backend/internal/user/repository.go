@@ -3,24 +3,55 @@ package user
 import (
 	"database/sql"
 	"errors"
+	"log"
+	"strconv"
 	"time"
 
 	"github.com/bharabhi01/authservice/pkg/database"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/bharabhi01/authservice/pkg/password"
+	"github.com/lib/pq"
 )
 
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken = errors.New("email is already in use")
+	ErrUsernameTaken = errors.New("username is already in use")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInactive = errors.New("account is not active")
+)
+
+// Replaced password hashes kept per user, PASSWORD_HISTORY cannot look further back than this
+const maxPasswordHistory = 24
+
+// Role every new user is given
+const defaultRole = "user"
+
+// validID reports whether id can be a user ID at all, so malformed IDs are not sent to the database
+func validID(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 32)
+	return err == nil
+}
+
+// Columns read into a User, in the order scanUser expects them
+// Roles come from user_roles, which is the only place role assignments are stored
+const userColumns = `id, username, email, password_hash, first_name, last_name,
+	ARRAY(SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id ORDER BY r.name),
+	active, email_verified, email_verified_at, created_at, updated_at`
+
 type Repository struct {
 	db *sql.DB
+	passwords *password.Manager
 }
 
-func NewRepository() *Repository {
+func NewRepository(passwords *password.Manager) *Repository {
 	return &Repository{
 		db: database.DB,
+		passwords: passwords,
 	}
 }
 
 func (r *Repository) Create(user *UserRegistration) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := r.passwords.Hash(user.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -30,77 +61,114 @@ func (r *Repository) Create(user *UserRegistration) (*User, error) {
 	newUser := &User{
 		Username: user.Username,
 		Email: user.Email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
+		FirstName: user.FirstName,
+		LastName: user.LastName,
+		Active: true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := r.insert(newUser); err != nil {
+		return nil, err
+	}
+
+	return newUser, nil
+}
+
+// Import creates a user with a password hash taken over from another system
+// The hash is kept as is until the user's next login replaces it with one of the current algorithm
+func (r *Repository) Import(user *UserImport) (*User, error) {
+	if err := r.passwords.Check(user.PasswordHash); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	newUser := &User{
+		Username: user.Username,
+		Email: user.Email,
+		PasswordHash: user.PasswordHash,
 		FirstName: user.FirstName,
 		LastName: user.LastName,
-		Role: "user",
 		Active: true,
+		EmailVerified: user.EmailVerified,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
+	if user.EmailVerified {
+		newUser.EmailVerifiedAt = &now
+	}
+
+	if err := r.insert(newUser); err != nil {
+		return nil, err
+	}
+
+	return newUser, nil
+}
+
+// insert stores a new user together with their default role assignment
+func (r *Repository) insert(newUser *User) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
 	query := `
-		INSERT INTO users (username, email, password_hash, first_name, last_name, role, active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, username, email, password_hash, first_name, last_name, role, active, created_at, updated_at
+		INSERT INTO users (username, email, password_hash, first_name, last_name, active, email_verified, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
 	`
 
-	err = r.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		newUser.Username,
 		newUser.Email,
 		newUser.PasswordHash,
 		newUser.FirstName,
 		newUser.LastName,
-		newUser.Role,
 		newUser.Active,
+		newUser.EmailVerified,
+		newUser.EmailVerifiedAt,
 		newUser.CreatedAt,
 		newUser.UpdatedAt,
-	).Scan(
-		&newUser.ID,
-		&newUser.Username,
-		&newUser.Email,
-		&newUser.PasswordHash,
-		&newUser.FirstName,
-		&newUser.LastName,
-		&newUser.Role,
-		&newUser.Active,
-		&newUser.CreatedAt,
-		&newUser.UpdatedAt,
-	)
-
+	).Scan(&newUser.ID)
 	if err != nil {
-		return nil, err
+		return uniqueViolation(err)
 	}
 
-	return newUser, nil
+	query = `
+		INSERT INTO user_roles (user_id, role_id, created_at)
+		SELECT $1, id, $3 FROM roles WHERE name = $2
+	`
+
+	if _, err := tx.Exec(query, newUser.ID, defaultRole, newUser.CreatedAt); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	newUser.Roles = []string{defaultRole}
+	return nil
 }
 
 func (r *Repository) GetByUsername(username string) (*User, error) {
 	user := &User{}
 
 	query := `
-		SELECT id, username, email, password_hash, first_name, last_name, role, active, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE username = $1
 	`
 
-	err := r.db.QueryRow(query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash,
-		&user.FirstName,
-		&user.LastName,
-		&user.Role,
-		&user.Active,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := scanUser(r.db.QueryRow(query, username), user)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -109,38 +177,284 @@ func (r *Repository) GetByUsername(username string) (*User, error) {
 }
 
 func (r *Repository) GetByID(id string) (*User, error) {
+	if !validID(id) {
+		return nil, ErrUserNotFound
+	}
+
 	user := &User{}
 
 	query := `
-		SELECT id, username, email, password_hash, first_name, last_name, role, active, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = $1
 	`
 
-	err := r.db.QueryRow(query, id).Scan(
+	err := scanUser(r.db.QueryRow(query, id), user)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail returns the user with the given email address
+func (r *Repository) GetByEmail(email string) (*User, error) {
+	user := &User{}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE email = $1
+	`
+
+	err := scanUser(r.db.QueryRow(query, email), user)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// MarkEmailVerified records that a user proved they own their email address
+func (r *Repository) MarkEmailVerified(id string) error {
+	query := `
+		UPDATE users
+		SET email_verified = TRUE, email_verified_at = $2, updated_at = $2
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(query, id, time.Now())
+	return err
+}
+
+// Update saves the editable fields of a user and maintains updated_at
+// Roles are not touched, they are assigned through the role repository
+// It returns ErrEmailTaken or ErrUsernameTaken when the change collides with another account
+func (r *Repository) Update(user *User) error {
+	user.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE users
+		SET username = $2, email = $3, first_name = $4, last_name = $5, active = $6,
+			email_verified = $7, email_verified_at = $8, updated_at = $9
+		WHERE id = $1
+	`
+
+	result, err := r.db.Exec(
+		query,
+		user.ID,
+		user.Username,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Active,
+		user.EmailVerified,
+		user.EmailVerifiedAt,
+		user.UpdatedAt,
+	)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a user; their sessions, tokens and role assignments go with them
+func (r *Repository) Delete(id string) error {
+	result, err := r.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword hashes and stores a new password for a user
+// The replaced hash is kept in the password history, which holds at most maxPasswordHistory entries per user
+func (r *Repository) UpdatePassword(id, password string) error {
+	hashedPassword, err := r.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var previous string
+	if err := tx.QueryRow(`SELECT password_hash FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&previous); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	query := `
+		UPDATE users
+		SET password_hash = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	if _, err := tx.Exec(query, id, hashedPassword, now); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO password_history (user_id, password_hash, created_at) VALUES ($1, $2, $3)`, id, previous, now); err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2
+		)
+	`
+
+	if _, err := tx.Exec(query, id, maxPasswordHistory); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PasswordHistory returns the hashes of the current and up to n-1 previous passwords of a user, newest first
+func (r *Repository) PasswordHistory(user *User, n int) ([]string, error) {
+	history := []string{user.PasswordHash}
+	if n <= 1 {
+		return history, nil
+	}
+
+	query := `
+		SELECT password_hash
+		FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(query, user.ID, n-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		history = append(history, hash)
+	}
+
+	return history, rows.Err()
+}
+
+func (r *Repository) VerifyPassword(user *User, password string) bool {
+	ok, _, err := r.passwords.Verify(password, user.PasswordHash)
+	return err == nil && ok
+}
+
+// Authenticate checks a username and password pair
+// It returns ErrInvalidCredentials for an unknown user or a wrong password, and ErrInactive for disabled accounts
+// Unknown users are checked against a dummy hash, so the response time does not reveal which usernames exist
+// A hash made with an outdated algorithm or parameters is replaced while the plain password is at hand
+func (r *Repository) Authenticate(username, password string) (*User, error) {
+	user, err := r.GetByUsername(username)
+	if err != nil {
+		r.passwords.VerifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, rehash, err := r.passwords.Verify(password, user.PasswordHash)
+	if err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if rehash {
+		if err := r.rehash(user, password); err != nil {
+			log.Printf("Failed to rehash password of user %s: %v", user.ID, err)
+		}
+	}
+
+	if !user.Active {
+		return user, ErrInactive
+	}
+
+	return user, nil
+}
+
+// rehash stores a fresh hash of the password without touching updated_at, the password itself did not change
+func (r *Repository) rehash(user *User, password string) error {
+	hashedPassword, err := r.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`UPDATE users SET password_hash = $2 WHERE id = $1 AND password_hash = $3`, user.ID, hashedPassword, user.PasswordHash)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = hashedPassword
+	return nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a row selected with userColumns
+func scanUser(row scanner, user *User) error {
+	return row.Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
 		&user.FirstName,
 		&user.LastName,
-		&user.Role,
+		pq.Array(&user.Roles),
 		&user.Active,
+		&user.EmailVerified,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
+}
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+// uniqueViolation maps unique constraint errors on users to ErrEmailTaken and ErrUsernameTaken
+func uniqueViolation(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_email_key":
+			return ErrEmailTaken
+		case "users_username_key":
+			return ErrUsernameTaken
 		}
-		return nil, err
 	}
-
-	return user, nil
+	return err
 }
-
-func (r *Repository) VerifyPassword(user *User, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-	return err == nil
-}
\ No newline at end of file
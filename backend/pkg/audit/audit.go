@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/bharabhi01/authservice/pkg/database" 
 )
 
+// LogEntry is a row of the audit_logs table
+// UserID is the user the request was made as, ActorID the admin behind it when impersonating
 type LogEntry struct {
 	ID string `json:"id"`
 	UserID string `json:"user_id"`
+	ActorID string `json:"actor_id,omitempty"`
 	Action string `json:"action"`
 	ResourceType string `json:"resource_type"`
 	ResourceID string `json:"resource_id"`
@@ -38,15 +42,16 @@ func (l *Logger) Log(ctx context.Context, entry *LogEntry) error {
 	}
 
 	query := `
-		INSERT INTO audit_logs (user_id, action, resource_type, resource_id, ip_address, user_agent, details, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO audit_logs (user_id, actor_id, action, resource_type, resource_id, ip_address, user_agent, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id
 	`
 
 	err := l.db.QueryRowContext(
 		ctx,
 		query,
-		entry.UserID,
+		nullIfEmpty(entry.UserID),
+		nullIfEmpty(entry.ActorID),
 		entry.Action,
 		entry.ResourceType,
 		entry.ResourceID,
@@ -60,11 +65,9 @@ func (l *Logger) Log(ctx context.Context, entry *LogEntry) error {
 }
 
 func (l *Logger) LogFromGin(c *gin.Context, action, resourceType, resourceID string, details interface{}) error {
-	userID, _ := c.Get("user_id")
-	userIDStr, ok := userID.(string)
-	if !ok {
-		userIDStr = ""
-	}
+	// Set by AuthMiddleware, actorID only when an admin is impersonating the user
+	userIDStr := c.GetString("userID")
+	actorIDStr := c.GetString("actorID")
 
 	var detailsJSON json.RawMessage
 	if details != nil {
@@ -77,6 +80,7 @@ func (l *Logger) LogFromGin(c *gin.Context, action, resourceType, resourceID str
 
 	entry := &LogEntry{
 		UserID: userIDStr,
+		ActorID: actorIDStr,
 		Action: action,
 		ResourceType: resourceType,
 		ResourceID: resourceID,
@@ -89,9 +93,9 @@ func (l *Logger) LogFromGin(c *gin.Context, action, resourceType, resourceID str
 	return l.Log(c.Request.Context(), entry)
 }
 
-func (l *Logger) GetLogs(ctx context.Context, userID, action, resourceType string, limit, offset int) ([]LogEntry, error) {
+func (l *Logger) GetLogs(ctx context.Context, userID, actorID, action, resourceType string, limit, offset int) ([]LogEntry, error) {
 	query := `
-		SELECT id, user_id, action, resource_type, resource_id, ip_address, user_agent, details, created_at
+		SELECT id, COALESCE(user_id::text, ''), COALESCE(actor_id::text, ''), action, resource_type, resource_id, ip_address, user_agent, details, created_at
 		FROM audit_logs
 		WHERE 1=1
 	`
@@ -100,24 +104,30 @@ func (l *Logger) GetLogs(ctx context.Context, userID, action, resourceType strin
 	argCount := 1
 
 	if userID != "" {
-		query += ` AND user_id = $` + string(argCount)
+		query += ` AND user_id = $` + strconv.Itoa(argCount)
 		args = append(args, userID)
 		argCount++
 	}
 
+	if actorID != "" {
+		query += ` AND actor_id = $` + strconv.Itoa(argCount)
+		args = append(args, actorID)
+		argCount++
+	}
+
 	if action != "" {
-		query += ` AND action = $` + string(argCount)
+		query += ` AND action = $` + strconv.Itoa(argCount)
 		args = append(args, action)
 		argCount++
 	}
 
 	if resourceType != "" {
-		query += ` AND resource_type = $` + string(argCount)
+		query += ` AND resource_type = $` + strconv.Itoa(argCount)
 		args = append(args, resourceType)
 		argCount++
 	}
 
-	query += ` ORDER BY created_at DESC LIMIT $` + string(argCount) + ` OFFSET $` + string(argCount + 1)
+	query += ` ORDER BY created_at DESC LIMIT $` + strconv.Itoa(argCount) + ` OFFSET $` + strconv.Itoa(argCount + 1)
 	args = append(args, limit, offset)
 
 	rows, err := l.db.QueryContext(ctx, query, args...)
@@ -132,6 +142,7 @@ func (l *Logger) GetLogs(ctx context.Context, userID, action, resourceType strin
 		if err := rows.Scan(
 			&log.ID,
 			&log.UserID,
+			&log.ActorID,
 			&log.Action,
 			&log.ResourceType,
 			&log.ResourceID,
@@ -150,4 +161,12 @@ func (l *Logger) GetLogs(ctx context.Context, userID, action, resourceType strin
 	}
 
 	return logs, nil
-}
\ No newline at end of file
+}
+
+// nullIfEmpty stores missing IDs as NULL instead of an invalid integer
+func nullIfEmpty(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,130 @@
+package authcookie
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/gin-gonic/gin"
+)
+
+// Names of the cookies and header used in cookie mode
+const (
+	AccessTokenName  = "access_token"
+	RefreshTokenName = "refresh_token"
+	CSRFTokenName    = "csrf_token"
+	CSRFHeaderName   = "X-CSRF-Token"
+)
+
+// The token cookies are only sent to the API, the refresh token only to the endpoints that use it.
+// The CSRF cookie is readable by scripts on every page so the frontend can copy it into the header
+const (
+	accessTokenPath  = "/api/v1"
+	refreshTokenPath = "/api/v1/auth"
+	csrfTokenPath    = "/"
+)
+
+// SetTokens stores the access and refresh tokens in HttpOnly cookies
+// A fresh CSRF token is set alongside them and returned
+func SetTokens(c *gin.Context, cfg *config.Config, accessToken, refreshToken string) (string, error) {
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	accessMaxAge := int((time.Duration(cfg.JWTExpirationHours) * time.Hour).Seconds())
+	refreshMaxAge := int((time.Duration(cfg.RefreshExpirationDays) * 24 * time.Hour).Seconds())
+
+	c.SetSameSite(sameSite(cfg.CookieSameSite))
+	c.SetCookie(AccessTokenName, accessToken, accessMaxAge, accessTokenPath, cfg.CookieDomain, cfg.CookieSecure, true)
+	if refreshToken != "" {
+		c.SetCookie(RefreshTokenName, refreshToken, refreshMaxAge, refreshTokenPath, cfg.CookieDomain, cfg.CookieSecure, true)
+	}
+	c.SetCookie(CSRFTokenName, csrfToken, refreshMaxAge, csrfTokenPath, cfg.CookieDomain, cfg.CookieSecure, false)
+
+	return csrfToken, nil
+}
+
+// Clear expires every cookie set by SetTokens
+func Clear(c *gin.Context, cfg *config.Config) {
+	c.SetSameSite(sameSite(cfg.CookieSameSite))
+	c.SetCookie(AccessTokenName, "", -1, accessTokenPath, cfg.CookieDomain, cfg.CookieSecure, true)
+	c.SetCookie(RefreshTokenName, "", -1, refreshTokenPath, cfg.CookieDomain, cfg.CookieSecure, true)
+	c.SetCookie(CSRFTokenName, "", -1, csrfTokenPath, cfg.CookieDomain, cfg.CookieSecure, false)
+}
+
+// HasTokens reports whether the request carries a token cookie, and so could be forged cross-site
+func HasTokens(c *gin.Context) bool {
+	if v, err := c.Cookie(AccessTokenName); err == nil && v != "" {
+		return true
+	}
+	if v, err := c.Cookie(RefreshTokenName); err == nil && v != "" {
+		return true
+	}
+	return false
+}
+
+// VerifyCSRF checks the X-CSRF-Token header against the CSRF cookie (double-submit)
+// A cross-site attacker can make the browser send the cookie but cannot read it to fill in the header
+func VerifyCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(CSRFTokenName)
+	if err != nil || cookie == "" {
+		return false
+	}
+
+	header := c.GetHeader(CSRFHeaderName)
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// FormCSRFName names both the cookie and the form field carrying the CSRF token of server rendered forms
+const FormCSRFName = "form_csrf_token"
+
+// SetFormCSRF stores a fresh CSRF token for a server rendered form in an HttpOnly cookie scoped to path
+// The token is returned to be put in a hidden field of the form
+func SetFormCSRF(c *gin.Context, cfg *config.Config, path string) (string, error) {
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(FormCSRFName, csrfToken, 0, path, cfg.CookieDomain, cfg.CookieSecure, true)
+
+	return csrfToken, nil
+}
+
+// VerifyFormCSRF checks the form_csrf_token form field against the cookie set by SetFormCSRF
+// A cross-site form cannot know the token, and a strict cookie is not sent with it anyway
+func VerifyFormCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(FormCSRFName)
+	if err != nil || cookie == "" {
+		return false
+	}
+
+	field := c.PostForm(FormCSRFName)
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(field)) == 1
+}
+
+// sameSite maps the COOKIE_SAMESITE setting to its http.SameSite value
+func sameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -19,7 +21,22 @@ type Config struct {
 
 	JWTSecret string
 	JWTExpirationHours int
+	// Audience of tokens issued for this service's own API, checked by AuthMiddleware
+	JWTAudience string
+	// HS256 signs with JWTSecret, RS256/ES256/EdDSA sign with keys from JWTKeysDir
+	// The OpenID Connect provider is only enabled with an asymmetric algorithm
+	JWTSigningAlg string
+	// Replicas share keys through JWTKeysDir, a shared volume, and should leave rotation to one of them
+	JWTKeysDir string
+	JWTActiveKeyID string
+	JWTKeyRotationHours int
 	RefreshExpirationDays int
+	// Tokens without an aud claim predate audiences, they are accepted until this time and never when it is unset
+	AudiencelessTokensUntil time.Time
+	// Lifetime of tokens issued to admins impersonating a user
+	ImpersonationTokenMinutes int
+	// Which roles may assign which others, as granter:role|role entries where * stands for any role
+	RoleAssignmentRules []string
 
 	DBHost string
 	DBPort string
@@ -28,11 +45,97 @@ type Config struct {
 	DBName string
 	DBSSLMode string
 
+	// Public base URL of this service, used as the OpenID Connect issuer
+	IssuerURL string
+	// Relying parties in the form "client_id=redirect_uri1,redirect_uri2;client_id2=..."
+	OIDCClients string
+
 	RedisAddr string
 	RedisPassword string
 	RedisDB int
+
+	// Where revoked access tokens are tracked: "memory" or "redis"
+	RevocationStore string
+
+	// Algorithm for new password hashes: "argon2id" or "bcrypt"
+	// Hashes of the other algorithm or with other parameters are replaced on the next login
+	PasswordHashAlgorithm string
+	Argon2MemoryKB int
+	Argon2Iterations int
+	Argon2Parallelism int
+	BcryptCost int
+
+	// Rules for new passwords, lengths are in characters
+	PasswordMinLength int
+	PasswordMaxLength int
+	PasswordRequireUppercase bool
+	PasswordRequireLowercase bool
+	PasswordRequireDigit bool
+	PasswordRequireSymbol bool
+	// Reject passwords containing the username or email address
+	PasswordRejectUserInfo bool
+	// How many recent passwords cannot be reused, 0 disables the check
+	PasswordHistory int
+	// Local copy of the Have I Been Pwned SHA-1 corpus, a directory of range files or one sorted file
+	BreachedPasswordsPath string
+	// Passwords seen fewer times than this in the corpus are accepted
+	BreachedPasswordsMinCount int
+
+	// Where failed login counters are tracked: "memory" or "redis"
+	ThrottleStore string
+	// Failed logins per account before each further attempt is delayed, doubling from LoginBackoffSeconds
+	LoginFreeAttempts int
+	LoginBackoffSeconds int
+	LoginMaxBackoffSeconds int
+	// Failed logins after which the account is locked for LockoutMinutes, 0 disables the lockout
+	LockoutThreshold int
+	LockoutMinutes int
+	// The same limits for all logins from a single client IP
+	IPFreeAttempts int
+	IPLockoutThreshold int
+
+	// In cookie mode tokens are handed out as HttpOnly cookies and state-changing requests need a CSRF token
+	AuthCookieMode bool
+	CookieDomain string
+	CookieSecure bool
+	// "strict", "lax" or "none"
+	CookieSameSite string
 	
+	// Base URL of the frontend, used for links in emails
+	AppURL string
+
+	// "smtp", "outbox" or "log", only smtp delivers anything so production requires it
+	Mailer string
+	MailFrom string
+	MailOutboxDir string
+	SMTPHost string
+	SMTPPort string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Whether users must verify their email address before they can log in
+	RequireEmailVerification bool
+	EmailVerificationHours int
+	PasswordResetMinutes int
+
+	// Issuer shown in authenticator apps
+	MFAIssuer string
+	// Base64 encoded 32 byte key TOTP secrets are encrypted with, required outside development
+	MFAEncryptionKey string
+	// Lifetime of the mfa_pending token handed out after the password step
+	MFAPendingMinutes int
+
+	// WebAuthn relying party: the domain passkeys are bound to and the origins allowed to use them
+	WebAuthnRPID string
+	WebAuthnRPName string
+	WebAuthnOrigins []string
+
+	// Origins allowed to call the API from a browser, "*" allows any but cannot be combined with cookie mode
 	CORSAllowOrigins []string	
+
+	// Proxies whose X-Forwarded-For header is believed, none by default
+	// Client IPs key the login throttle, so a header anyone can set must not change them
+	TrustedProxies []string
 }
 
 func Load() (*Config, error) {
@@ -48,23 +151,96 @@ func Load() (*Config, error) {
 		ShutdownTimeout:      time.Duration(getEnvAsInt("SHUTDOWN_TIMEOUT", 5)) * time.Second,
 		JWTSecret:            getEnv("JWT_SECRET", "your_jwt_secret_key_here"),
 		JWTExpirationHours:   getEnvAsInt("JWT_EXPIRATION_HOURS", 24),
+		JWTAudience:          getEnv("JWT_AUDIENCE", "authservice"),
+		JWTSigningAlg:        getEnv("JWT_SIGNING_ALG", "HS256"),
+		JWTKeysDir:           getEnv("JWT_KEYS_DIR", ""),
+		JWTActiveKeyID:       getEnv("JWT_ACTIVE_KEY_ID", ""),
+		JWTKeyRotationHours:  getEnvAsInt("JWT_KEY_ROTATION_HOURS", 0),
 		RefreshExpirationDays: getEnvAsInt("REFRESH_EXPIRATION_DAYS", 7),
+		ImpersonationTokenMinutes: getEnvAsInt("IMPERSONATION_TOKEN_MINUTES", 15),
+		RoleAssignmentRules:  getEnvAsSlice("ROLE_ASSIGNMENT_RULES", []string{"admin:*"}),
 		DBHost:               getEnv("DB_HOST", "localhost"),
 		DBPort:               getEnv("DB_PORT", "5432"),
 		DBUser:               getEnv("DB_USER", "postgres"),
 		DBPassword:           getEnv("DB_PASSWORD", "postgres"),
 		DBName:               getEnv("DB_NAME", "authservice"),
 		DBSSLMode:            getEnv("DB_SSLMODE", "disable"),
+		IssuerURL:            getEnv("ISSUER_URL", "http://localhost:8080"),
+		OIDCClients:          getEnv("OIDC_CLIENTS", ""),
 		RedisAddr:            getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisPassword:        getEnv("REDIS_PASSWORD", ""),
 		RedisDB:              getEnvAsInt("REDIS_DB", 0),
+		RevocationStore:      getEnv("REVOCATION_STORE", "memory"),
+		PasswordHashAlgorithm: getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		Argon2MemoryKB:       getEnvAsInt("ARGON2_MEMORY_KB", 64*1024),
+		Argon2Iterations:     getEnvAsInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:    getEnvAsInt("ARGON2_PARALLELISM", 2),
+		BcryptCost:           getEnvAsInt("BCRYPT_COST", 12),
+		PasswordMinLength:    getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:    getEnvAsInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordRequireUppercase: getEnvAsBool("PASSWORD_REQUIRE_UPPERCASE", false),
+		PasswordRequireLowercase: getEnvAsBool("PASSWORD_REQUIRE_LOWERCASE", false),
+		PasswordRequireDigit: getEnvAsBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol: getEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordRejectUserInfo: getEnvAsBool("PASSWORD_REJECT_USER_INFO", true),
+		PasswordHistory:      getEnvAsInt("PASSWORD_HISTORY", 5),
+		BreachedPasswordsPath: getEnv("BREACHED_PASSWORDS_PATH", ""),
+		BreachedPasswordsMinCount: getEnvAsInt("BREACHED_PASSWORDS_MIN_COUNT", 1),
+		ThrottleStore:        getEnv("THROTTLE_STORE", "memory"),
+		LoginFreeAttempts:    getEnvAsInt("LOGIN_FREE_ATTEMPTS", 3),
+		LoginBackoffSeconds:  getEnvAsInt("LOGIN_BACKOFF_SECONDS", 1),
+		LoginMaxBackoffSeconds: getEnvAsInt("LOGIN_MAX_BACKOFF_SECONDS", 60),
+		LockoutThreshold:     getEnvAsInt("LOCKOUT_THRESHOLD", 10),
+		LockoutMinutes:       getEnvAsInt("LOCKOUT_MINUTES", 15),
+		IPFreeAttempts:       getEnvAsInt("IP_FREE_ATTEMPTS", 20),
+		IPLockoutThreshold:   getEnvAsInt("IP_LOCKOUT_THRESHOLD", 100),
+		AuthCookieMode:       getEnvAsBool("AUTH_COOKIE_MODE", false),
+		CookieDomain:         getEnv("COOKIE_DOMAIN", ""),
+		CookieSecure:         getEnvAsBool("COOKIE_SECURE", true),
+		CookieSameSite:       getEnv("COOKIE_SAMESITE", "strict"),
+		AppURL:               getEnv("APP_URL", "http://localhost:3000"),
+		Mailer:               getEnv("MAILER", "log"),
+		MailFrom:             getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailOutboxDir:        getEnv("MAIL_OUTBOX_DIR", ""),
+		SMTPHost:             getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:             getEnv("SMTP_PORT", "587"),
+		SMTPUsername:         getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:         getEnv("SMTP_PASSWORD", ""),
+		RequireEmailVerification: getEnvAsBool("REQUIRE_EMAIL_VERIFICATION", false),
+		EmailVerificationHours: getEnvAsInt("EMAIL_VERIFICATION_HOURS", 24),
+		PasswordResetMinutes: getEnvAsInt("PASSWORD_RESET_MINUTES", 60),
+		MFAIssuer:            getEnv("MFA_ISSUER", "authservice"),
+		MFAEncryptionKey:     getEnv("MFA_ENCRYPTION_KEY", ""),
+		MFAPendingMinutes:    getEnvAsInt("MFA_PENDING_MINUTES", 5),
+		WebAuthnRPID:         getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:       getEnv("WEBAUTHN_RP_NAME", "authservice"),
+		WebAuthnOrigins:      getEnvAsSlice("WEBAUTHN_ORIGINS", []string{"http://localhost:3000"}),
 		CORSAllowOrigins:     getEnvAsSlice("CORS_ALLOW_ORIGINS", []string{"*"}),
+		TrustedProxies:       getEnvAsSlice("TRUSTED_PROXIES", nil),
+	}
+
+	if value := getEnv("ACCEPT_AUDIENCELESS_TOKENS_UNTIL", ""); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("ACCEPT_AUDIENCELESS_TOKENS_UNTIL must be an RFC 3339 time: %v", err)
+		}
+		config.AudiencelessTokensUntil = until
 	}
 
 	// Validate required configuration
-	if config.JWTSecret == "your_jwt_secret_key_here" && config.Env == "production" {
+	if config.JWTSigningAlg == "HS256" && config.JWTSecret == "your_jwt_secret_key_here" && config.Env == "production" {
 		return nil, fmt.Errorf("JWT_SECRET must be set in production environment")
 	}
+	// Browsers refuse credentialed responses that allow every origin, so cookies would never be sent
+	if config.AuthCookieMode && slices.Contains(config.CORSAllowOrigins, "*") {
+		return nil, fmt.Errorf("CORS_ALLOW_ORIGINS must list the allowed origins when AUTH_COOKIE_MODE is enabled, \"*\" cannot be used")
+	}
+	if config.Mailer != "smtp" && config.Env == "production" {
+		return nil, fmt.Errorf("MAILER must be smtp in production environment, %q does not deliver emails", config.Mailer)
+	}
+	if config.MFAEncryptionKey == "" && config.Env != "development" {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be set outside the development environment")
+	}
 
 	return config, nil
 }
@@ -84,11 +260,26 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsSlice reads a comma separated list
 func getEnvAsSlice(key string, defaultValue []string) []string {
 	valueStr := getEnv(key, "")
 	if valueStr == "" {
 		return defaultValue
 	}
 
-	return []string{valueStr}
+	var values []string
+	for _, value := range strings.Split(valueStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/bharabhi01/authservice/pkg/config"
+	"github.com/redis/go-redis/v9"
+)
+
+var Redis *redis.Client
+
+func InitRedis(cfg *config.Config) error {
+	Redis = redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	if err := Redis.Ping(context.Background()).Err(); err != nil {
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	log.Println("Successfully connected to redis!")
+
+	return nil
+}
+
+func CloseRedis() {
+	if Redis != nil {
+		Redis.Close()
+		log.Println("Redis connection closed")
+	}
+}
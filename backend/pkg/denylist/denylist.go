@@ -0,0 +1,38 @@
+package denylist
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps track of access tokens that were revoked before they expired
+// Entries only need to live until the revoked token would have expired anyway
+type Store interface {
+	// Revoke denies a single token, identified by its jti claim, until expiresAt
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsRevoked reports whether the token with the given jti was revoked
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeUser denies every token of a user issued at or before the given time
+	// The entry is kept for ttl, which should be at least the access token lifetime
+	RevokeUser(ctx context.Context, userID string, before time.Time, ttl time.Duration) error
+	// UserRevokedBefore returns the cut-off set by RevokeUser, or the zero time if none
+	UserRevokedBefore(ctx context.Context, userID string) (time.Time, error)
+}
+
+// IsTokenRevoked checks both the per-token and per-user entries for a token
+// issuedAt must keep sub-second precision, otherwise a token issued right after a revocation is denied too
+func IsTokenRevoked(ctx context.Context, store Store, jti, userID string, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		revoked, err := store.IsRevoked(ctx, jti)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	before, err := store.UserRevokedBefore(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return !before.IsZero() && !issuedAt.After(before), nil
+}
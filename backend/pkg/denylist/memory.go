@@ -0,0 +1,82 @@
+package denylist
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store
+// Revocations are lost on restart and are not shared between replicas
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]userEntry
+}
+
+type userEntry struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userEntry),
+	}
+}
+
+func (s *MemoryStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[jti] = expiresAt
+	s.purge()
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expiresAt, ok := s.tokens[jti]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+func (s *MemoryStore) RevokeUser(ctx context.Context, userID string, before time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userID] = userEntry{
+		before:    before,
+		expiresAt: time.Now().Add(ttl),
+	}
+	s.purge()
+	return nil
+}
+
+func (s *MemoryStore) UserRevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.users[userID]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return time.Time{}, nil
+	}
+	return entry.before, nil
+}
+
+// purge drops expired entries, the caller must hold the write lock
+func (s *MemoryStore) purge() {
+	now := time.Now()
+	for jti, expiresAt := range s.tokens {
+		if !now.Before(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, entry := range s.users {
+		if !now.Before(entry.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package denylist
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tokenKeyPrefix = "denylist:jti:"
+	userKeyPrefix  = "denylist:user:"
+)
+
+// RedisStore is a Store shared by every replica through Redis
+// Keys expire on their own, so no cleanup is needed
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (s *RedisStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, tokenKeyPrefix+jti, 1, ttl).Err()
+}
+
+func (s *RedisStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := s.client.Exists(ctx, tokenKeyPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RevokeUser stores the cut-off in Unix nanoseconds, tokens issued in the same second as it are told apart
+func (s *RedisStore) RevokeUser(ctx context.Context, userID string, before time.Time, ttl time.Duration) error {
+	return s.client.Set(ctx, userKeyPrefix+userID, before.UnixNano(), ttl).Err()
+}
+
+func (s *RedisStore) UserRevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	value, err := s.client.Get(ctx, userKeyPrefix+userID).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos), nil
+}
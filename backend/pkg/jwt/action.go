@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Purposes of action tokens
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+	PurposeMFAPending        = "mfa_pending"
+)
+
+var ErrWrongPurpose = errors.New("token was issued for another purpose")
+
+// ActionClaims are the claims of single-purpose tokens sent to users, e.g. in an email link
+// They are issued for their own audience so AuthMiddleware never accepts them as access tokens
+// Binding ties the token to the state it was issued for, such as the email address being verified,
+// so callers can reject it once that state has changed
+type ActionClaims struct {
+	Purpose string `json:"purpose"`
+	Binding string `json:"bnd,omitempty"`
+	jwt.StandardClaims
+}
+
+// IssueActionToken signs a token allowing subject to perform purpose until lifetime runs out
+func IssueActionToken(purpose, subject, binding string, lifetime time.Duration) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return Sign(&ActionClaims{
+		Purpose: purpose,
+		Binding: binding,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			Subject:   subject,
+			Audience:  actionAudience(purpose),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
+			Issuer:    "authservice",
+		},
+	})
+}
+
+// ValidateActionToken checks the signature and expiry of an action token and that it was issued for purpose
+func ValidateActionToken(tokenString, purpose string) (*ActionClaims, error) {
+	claims := &ActionClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.Purpose != purpose || !claims.VerifyAudience(actionAudience(purpose), true) {
+		return nil, ErrWrongPurpose
+	}
+
+	return claims, nil
+}
+
+func actionAudience(purpose string) string {
+	return defaultAudience + ":" + purpose
+}
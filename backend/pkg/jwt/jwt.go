@@ -1,7 +1,11 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -11,70 +15,232 @@ import (
 var (
 	jwtSecret      string
 	expirationHours int
+	defaultAudience string
 )
 
 // Init initializes the JWT package with configuration
 // This should be called during application startup
-func Init(secret string, expHours int) {
+// audience is put in the aud claim of tokens that do not ask for another one
+func Init(secret string, expHours int, audience string) {
 	jwtSecret = secret
 	expirationHours = expHours
+	defaultAudience = audience
 }
 
+// DefaultAudience returns the audience of tokens issued for this service's own API
+func DefaultAudience() string {
+	return defaultAudience
+}
+
+// StandardClaims are the registered claims shared by every token this service issues
+type StandardClaims = jwt.StandardClaims
+
 // Claims represents the JWT claims structure
 // It extends the standard JWT claims with our custom fields
+// Tokens issued to OAuth2 clients through the client_credentials grant
+// leave the user fields empty and set ClientId instead
+// Audience shadows the single string aud of StandardClaims so that a token can name several audiences
+// Role is the single role claim of tokens issued before users could hold several roles,
+// it is only read through RoleNames and can go once those tokens have expired
+// IssuedAtNano repeats iat in nanoseconds, so a revocation and a login in the same second can be told apart
 type Claims struct {
-	UserId string `json:"user_id"`
-	Username string `json:"username"`
-	Role string `json:"role"`
+	UserId string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	Role string `json:"role,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	Scope string `json:"scope,omitempty"`
+	SessionId string `json:"sid,omitempty"`
+	Audience Audience `json:"aud,omitempty"`
+	Actor *Actor `json:"act,omitempty"`
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
 	jwt.StandardClaims
 }
 
-// GenerateToken generates a new JWT token for a user
-func GenerateToken(userId, username, role string) (string, error) {
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET is not set")
+// Actor is the RFC 8693 act claim naming who is acting on behalf of the subject
+// It is set on tokens an admin obtains by impersonating a user
+type Actor struct {
+	Subject string `json:"sub"`
+	Username string `json:"username,omitempty"`
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Audience is the aud claim, which may be a single string or an array of strings
+type Audience []string
+
+// MarshalJSON writes a single audience as a plain string, as most verifiers expect
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
 	}
 
-	// Set expiration time to 24 hours
-	expirationTime := time.Now().Add(time.Duration(expirationHours) * time.Hour)
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// HasAudience reports whether the token was issued for audience
+func (c *Claims) HasAudience(audience string) bool {
+	for _, a := range c.Audience {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScopes reports whether every one of scopes was granted to the token
+func (c *Claims) HasScopes(scopes ...string) bool {
+	granted := strings.Fields(c.Scope)
+	for _, scope := range scopes {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
 
-	// Create the JWT claims
-	claims := &Claims {
+// RoleNames returns the roles of the user the token was issued to
+// Tokens issued before the roles claim existed carry a single role instead
+func (c *Claims) RoleNames() []string {
+	if len(c.Roles) == 0 && c.Role != "" {
+		return []string{c.Role}
+	}
+	return c.Roles
+}
+
+// IssuedTime returns when the token was issued
+// Tokens without iat_ns only know the second, and are taken to be issued at its very start
+func (c *Claims) IssuedTime() time.Time {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// Principal returns the ID of the user or client the token was issued to
+func (c *Claims) Principal() string {
+	if c.IsClient() {
+		return c.ClientId
+	}
+	return c.UserId
+}
+
+// IsImpersonation reports whether the token was issued to someone acting as its subject
+func (c *Claims) IsImpersonation() bool {
+	return c.Actor != nil
+}
+
+// IsClient reports whether the token identifies an OAuth2 client rather than a user
+func (c *Claims) IsClient() bool {
+	return c.ClientId != "" && c.UserId == ""
+}
+
+// GenerateToken generates a new JWT token for a user
+// The token is meant for this service's own API and is not restricted by scopes
+func GenerateToken(userId, username string, roles []string) (string, error) {
+	return Issue(&Claims {
 		UserId: userId,
 		Username: username,
-		Role: role,
+		Roles: roles,
+	})
+}
+
+// GenerateClientToken generates a token for an OAuth2 client acting on its own behalf
+func GenerateClientToken(clientId, scope string, audience []string, lifetime time.Duration) (string, error) {
+	return Issue(&Claims {
+		ClientId: clientId,
+		Scope: scope,
+		Audience: audience,
 		StandardClaims: jwt.StandardClaims {
-			ExpiresAt: expirationTime.Unix(),
-			IssuedAt: time.Now().Unix(),
-			Issuer: "authservice",
+			ExpiresAt: time.Now().Add(lifetime).Unix(),
 		},
+	})
+}
+
+// Issue fills in the registered claims and signs the token
+// jti, sub, iat, iss, the default audience and the expiration time are only set when missing,
+// so callers can build the custom claims (scope, session, ...) they need and leave the rest here
+func Issue(claims *Claims) (string, error) {
+	now := time.Now()
+
+	// Every token gets a unique ID so that it can be revoked individually
+	if claims.Id == "" {
+		tokenID, err := newTokenID()
+		if err != nil {
+			return "", err
+		}
+		claims.Id = tokenID
 	}
 
-	// Create a new token with the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString([]byte(jwtSecret))
-	if err != nil {
-		return "", err
+	if claims.Subject == "" {
+		claims.Subject = claims.Principal()
+	}
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = now.Unix()
+		claims.IssuedAtNano = now.UnixNano()
 	}
+	if claims.ExpiresAt == 0 {
+		// Set expiration time to JWT_EXPIRATION_HOURS
+		claims.ExpiresAt = now.Add(time.Duration(expirationHours) * time.Hour).Unix()
+	}
+	if claims.Issuer == "" {
+		claims.Issuer = "authservice"
+	}
+	claims.Audience = audienceOrDefault(claims.Audience)
 
-	return tokenString, nil
+	return Sign(claims)
 }
 
-func ValidateToken(tokenString string) (*Claims, error) {
+// Sign signs any set of claims with the active key
+// Asymmetric keys put their kid in the token header so verifiers can pick the right public key
+func Sign(claims jwt.Claims) (string, error) {
+	if keys != nil {
+		key := keys.activeKey()
+		method, err := methodFor(key.Algorithm)
+		if err != nil {
+			return "", err
+		}
+
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = key.ID
+		return token.SignedString(key.Private)
+	}
+
 	if jwtSecret == "" {
-		return nil, errors.New("JWT_SECRET is not set")
+		return "", errors.New("JWT_SECRET is not set")
 	}
 
-	// Parse the token with the secret key
+	// Create a new token with the claims
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	// Sign the token with the secret key
+	return token.SignedString([]byte(jwtSecret))
+}
+
+func ValidateToken(tokenString string) (*Claims, error) {
+	// Parse the token with the matching verification key
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(jwtSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -87,3 +253,50 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// Lifetime returns how long an access token stays valid after it is issued
+func Lifetime() time.Duration {
+	return time.Duration(expirationHours) * time.Hour
+}
+
+// newTokenID generates a random value for the jti claim
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// verificationKey picks the key that verifies a token
+// With asymmetric signing the kid header selects the key and the algorithm must match it
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if keys != nil {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.lookup(kid)
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.Public(), nil
+	}
+
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET is not set")
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, errors.New("unexpected signing method")
+	}
+	return []byte(jwtSecret), nil
+}
+
+func audienceOrDefault(audience []string) Audience {
+	if len(audience) > 0 {
+		return audience
+	}
+	if defaultAudience == "" {
+		return nil
+	}
+	return Audience{defaultAudience}
+}
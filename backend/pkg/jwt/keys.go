@@ -0,0 +1,471 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey is an asymmetric key identified by its kid
+// Retired keys are no longer used for signing but still verify tokens until RetiredAt + retention
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetiredAt time.Time
+}
+
+// Public returns the public half of the key
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// reloadInterval is how often an unknown kid may make the key directory be read again
+// Reading it only parses files not seen before, so this mostly guards against floods of made up kids
+const reloadInterval = time.Second
+
+// keyRing holds the active signing key and the keys that may still verify tokens
+// pinned is the kid chosen by JWT_ACTIVE_KEY_ID, it stays active until a newer key is rotated in
+// expired remembers pruned kids, so their files are not parsed again on every reload
+type keyRing struct {
+	mu         sync.RWMutex
+	algorithm  string
+	dir        string
+	pinned     string
+	active     *SigningKey
+	keys       map[string]*SigningKey
+	expired    map[string]bool
+	lastReload time.Time
+	lastMiss   time.Time
+}
+
+// keys is nil while tokens are signed with the shared HS256 secret
+var keys *keyRing
+
+// InitKeys switches token signing from the HS256 secret to an asymmetric algorithm
+// Supported algorithms are RS256, ES256 and EdDSA. Private keys are loaded from dir
+// as <kid>.pem files; activeKID selects the signing key, otherwise the newest file wins.
+// When dir is empty or holds no keys, a fresh key is generated (and saved if dir is set).
+//
+// Replicas share keys by sharing dir. Each one reads it again on StartKeyReload and whenever a token
+// names a kid it does not know, so keys rotated by one replica reach the others. Rotation should be
+// enabled on one replica only, otherwise every replica adds a key of its own each interval.
+func InitKeys(algorithm, dir, activeKID string) error {
+	if algorithm == "HS256" {
+		keys = nil
+		return nil
+	}
+
+	if _, err := methodFor(algorithm); err != nil {
+		return err
+	}
+
+	ring := &keyRing{
+		algorithm: algorithm,
+		dir:       dir,
+		pinned:    activeKID,
+		keys:      make(map[string]*SigningKey),
+		expired:   make(map[string]bool),
+	}
+
+	if dir != "" {
+		if err := ring.load(); err != nil {
+			return err
+		}
+	}
+
+	if ring.active == nil {
+		if _, err := ring.rotate(); err != nil {
+			return err
+		}
+	}
+
+	keys = ring
+	return nil
+}
+
+// Asymmetric reports whether tokens are signed with a private key, so others can verify them with the JWKS
+func Asymmetric() bool {
+	return keys != nil
+}
+
+// RotateKeys generates a new active signing key and retires the previous one
+// The retired key keeps verifying tokens until every token it signed has expired
+func RotateKeys() (*SigningKey, error) {
+	if keys == nil {
+		return nil, errors.New("key rotation requires an asymmetric signing algorithm")
+	}
+	return keys.rotate()
+}
+
+// StartKeyRotation rotates the signing key every interval until stop is closed
+func StartKeyRotation(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				key, err := RotateKeys()
+				if err != nil {
+					log.Printf("Failed to rotate signing key: %v", err)
+					continue
+				}
+				log.Printf("Rotated signing key, new kid %s", key.ID)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StartKeyReload reads the key directory again every interval until stop is closed
+// It lets replicas sharing the directory sign with, and publish, keys another replica rotated in
+func StartKeyReload(interval time.Duration, stop <-chan struct{}) {
+	if keys == nil || keys.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := keys.reload(); err != nil {
+					log.Printf("Failed to reload signing keys: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// VerificationKeys returns every key that currently verifies tokens, active key first
+func VerificationKeys() []*SigningKey {
+	if keys == nil {
+		return nil
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	keys.prune()
+
+	result := []*SigningKey{keys.active}
+	for _, key := range keys.keys {
+		if key != keys.active {
+			result = append(result, key)
+		}
+	}
+	sort.SliceStable(result[1:], func(i, j int) bool {
+		return result[i+1].CreatedAt.After(result[j+1].CreatedAt)
+	})
+	return result
+}
+
+func (r *keyRing) activeKey() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// lookup finds the key that verifies a token
+// An unknown kid may be a key another replica just rotated in, so the directory is read again, at most every reloadInterval
+func (r *keyRing) lookup(kid string) (*SigningKey, bool) {
+	key, ok := r.find(kid)
+	if ok || r.dir == "" {
+		return key, ok
+	}
+
+	r.mu.Lock()
+	stale := time.Since(r.lastMiss) >= reloadInterval
+	if stale {
+		r.lastMiss = time.Now()
+	}
+	r.mu.Unlock()
+	if !stale {
+		return nil, false
+	}
+
+	if err := r.reload(); err != nil {
+		log.Printf("Failed to reload signing keys: %v", err)
+		return nil, false
+	}
+	return r.find(kid)
+}
+
+func (r *keyRing) find(kid string) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, false
+	}
+	if !key.RetiredAt.IsZero() && time.Now().After(key.RetiredAt.Add(Lifetime())) {
+		return nil, false
+	}
+	return key, true
+}
+
+// reload reads the key directory again, for keys written by other replicas
+func (r *keyRing) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.load()
+}
+
+func (r *keyRing) rotate() (*SigningKey, error) {
+	key, err := generateKey(r.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.dir != "" {
+		if err := saveKey(r.dir, key); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.active != nil {
+		r.active.RetiredAt = time.Now()
+	}
+	r.active = key
+	r.pinned = ""
+	r.keys[key.ID] = key
+	r.prune()
+
+	return key, nil
+}
+
+// prune drops retired keys whose tokens have all expired, the caller must hold the write lock
+func (r *keyRing) prune() {
+	for kid, key := range r.keys {
+		if key != r.active && !key.RetiredAt.IsZero() && time.Now().After(key.RetiredAt.Add(Lifetime())) {
+			delete(r.keys, kid)
+			r.expired[kid] = true
+		}
+	}
+}
+
+// load reads the keys in the directory that the ring does not hold yet and picks the active key,
+// the caller must hold the write lock or own the ring alone
+// The files do not record when a key was retired, but rotation retires a key the moment the next one
+// is created, so every key but the active one counts as retired since the next newer key appeared
+func (r *keyRing) load() error {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	initial := r.lastReload.IsZero()
+	added := make(map[string]bool)
+
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if _, ok := r.keys[kid]; ok || r.expired[kid] {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		signer, err := parsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse signing key %s: %w", path, err)
+		}
+
+		key := &SigningKey{
+			ID:        kid,
+			Algorithm: r.algorithm,
+			Private:   signer,
+			CreatedAt: info.ModTime(),
+		}
+		if err := checkKeyType(r.algorithm, signer); err != nil {
+			return fmt.Errorf("signing key %s: %w", path, err)
+		}
+
+		r.keys[key.ID] = key
+		added[key.ID] = true
+	}
+	r.lastReload = time.Now()
+
+	if len(r.keys) == 0 {
+		return nil
+	}
+
+	byAge := make([]*SigningKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		byAge = append(byAge, key)
+	}
+	sort.Slice(byAge, func(i, j int) bool {
+		return byAge[i].CreatedAt.Before(byAge[j].CreatedAt)
+	})
+
+	// A key rotated in by another replica replaces the pinned one, as it would on the replica that rotated
+	active := byAge[len(byAge)-1]
+	if !initial && added[active.ID] {
+		r.pinned = ""
+	}
+	if r.pinned != "" {
+		key, ok := r.keys[r.pinned]
+		if !ok {
+			return fmt.Errorf("active signing key %s not found in %s", r.pinned, r.dir)
+		}
+		active = key
+	}
+
+	for i, key := range byAge {
+		switch {
+		case key == active:
+			key.RetiredAt = time.Time{}
+		case !key.RetiredAt.IsZero():
+		case i+1 < len(byAge):
+			key.RetiredAt = byAge[i+1].CreatedAt
+		default:
+			key.RetiredAt = time.Now()
+		}
+	}
+
+	r.active = active
+	r.prune()
+	return nil
+}
+
+func methodFor(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case "HS256":
+		return jwt.SigningMethodHS256, nil
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "ES256":
+		return jwt.SigningMethodES256, nil
+	case "EdDSA":
+		return signingMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+func generateKey(algorithm string) (*SigningKey, error) {
+	var signer crypto.Signer
+	var err error
+
+	switch algorithm {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:        hex.EncodeToString(id),
+		Algorithm: algorithm,
+		Private:   signer,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func checkKeyType(algorithm string, signer crypto.Signer) error {
+	switch k := signer.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == "RS256" {
+			return nil
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm == "ES256" && k.Curve == elliptic.P256() {
+			return nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm == "EdDSA" {
+			return nil
+		}
+	}
+	return fmt.Errorf("key type %T cannot be used with %s", signer, algorithm)
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+func saveKey(dir string, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Written under another name first, so replicas reading the directory never see half a key
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	path := filepath.Join(dir, key.ID+".pem")
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
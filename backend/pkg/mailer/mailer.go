@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bharabhi01/authservice/pkg/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New creates the mailer selected by MAILER: "smtp", "outbox" or "log"
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.Mailer {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "outbox":
+		return NewOutboxMailer(cfg.MailOutboxDir, cfg.MailFrom)
+	case "log", "":
+		return NewOutboxMailer("", cfg.MailFrom)
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer)
+	}
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutboxMailer is meant for local development
+// It writes every email as an .eml file to a directory, or to the log when no directory is set
+type OutboxMailer struct {
+	dir  string
+	from string
+}
+
+func NewOutboxMailer(dir, from string) (*OutboxMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &OutboxMailer{
+		dir:  dir,
+		from: from,
+	}, nil
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg *Message) error {
+	data := format(m.from, msg)
+
+	if m.dir == "" {
+		log.Printf("Email to %s:\n%s", msg.To, data)
+		return nil
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0600)
+}
+
+// format renders a message in RFC 5322 form
+func format(from string, msg *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// sanitize keeps an email address usable as part of a file name
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, address)
+}
@@ -0,0 +1,87 @@
+package password
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id hashes passwords with argon2id, encoded as $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+// Memory is in KiB
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt, err := randomSalt(a.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism, encode(salt), encode(key)), nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, error) {
+	h, memory, iterations, parallelism, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, iterations, memory, parallelism, uint32(len(h.hash)))
+	return equal(key, h.hash), nil
+}
+
+func (a *Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a *Argon2id) Check(encoded string) error {
+	_, _, _, _, err := parseArgon2id(encoded)
+	return err
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	h, memory, iterations, parallelism, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return memory != a.Memory ||
+		iterations != a.Iterations ||
+		parallelism != a.Parallelism ||
+		len(h.salt) != a.SaltLength ||
+		uint32(len(h.hash)) != a.KeyLength
+}
+
+func parseArgon2id(encoded string) (*phc, uint32, uint32, uint8, error) {
+	h, err := parsePHC(encoded)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if h.id != "argon2id" || h.version != strconv.Itoa(argon2.Version) {
+		return nil, 0, 0, 0, ErrMalformedHash
+	}
+
+	memory, err := h.intParam("m", maxArgon2Memory)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	iterations, err := h.intParam("t", maxArgon2Iterations)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	parallelism, err := h.intParam("p", maxArgon2Parallelism)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	return h, uint32(memory), uint32(iterations), uint8(parallelism), nil
+}
@@ -0,0 +1,57 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt hashes passwords with bcrypt, whose modular crypt format already carries the cost
+type Bcrypt struct {
+	Cost int
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	if err := b.Check(encoded); err != nil {
+		return false, err
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	// The policy keeps passwords this long from being set, so they cannot match
+	if err == bcrypt.ErrMismatchedHashAndPassword || err == bcrypt.ErrPasswordTooLong {
+		return false, nil
+	}
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return true, nil
+}
+
+func (b *Bcrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *Bcrypt) Check(encoded string) error {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return ErrMalformedHash
+	}
+	if cost > maxBcryptCost {
+		return fmt.Errorf("%w: cost is above the limit of %d", ErrMalformedHash, maxBcryptCost)
+	}
+	return nil
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
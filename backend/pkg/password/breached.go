@@ -0,0 +1,167 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedList looks passwords up in a local copy of the Have I Been Pwned SHA-1 corpus
+// The path is either a directory of range files named after the first 5 hex characters of the hash,
+// holding SUFFIX:COUNT lines as served by the range API, or a single file of HASH:COUNT lines ordered by hash
+// Passwords seen fewer than MinCount times are accepted
+type BreachedList struct {
+	path     string
+	dir      bool
+	MinCount int
+}
+
+func OpenBreachedList(path string, minCount int) (*BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if minCount < 1 {
+		minCount = 1
+	}
+
+	return &BreachedList{
+		path:     path,
+		dir:      info.IsDir(),
+		MinCount: minCount,
+	}, nil
+}
+
+// Contains reports whether a password appears in the corpus at least MinCount times
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	var count int
+	var err error
+	if b.dir {
+		count, err = b.lookupRange(hash)
+	} else {
+		count, err = b.lookupSorted(hash)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return count >= b.MinCount, nil
+}
+
+// lookupRange scans the range file of the hash prefix
+func (b *BreachedList) lookupRange(hash string) (int, error) {
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.path, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(b.path, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if candidate, count, ok := parseBreachedLine(scanner.Text()); ok && strings.EqualFold(candidate, suffix) {
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// lookupSorted binary searches the single sorted file, which is far too large to load into memory
+// The search narrows the file down to a small section that is then scanned line by line
+func (b *BreachedList) lookupSorted(hash string) (int, error) {
+	const scanSize = 64 * 1024
+
+	f, err := os.Open(b.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// lo is always the start of a line, and the hash cannot be on a line starting at or after hi
+	lo, hi := int64(0), info.Size()
+	for hi-lo > scanSize {
+		start, next, line, err := lineAt(f, lo+(hi-lo)/2)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			break
+		}
+
+		candidate, count, ok := parseBreachedLine(line)
+		if !ok {
+			return 0, ErrMalformedHash
+		}
+		switch strings.Compare(strings.ToUpper(candidate), hash) {
+		case 0:
+			return count, nil
+		case -1:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+
+	scanner := bufio.NewScanner(io.NewSectionReader(f, lo, hi-lo))
+	for scanner.Scan() {
+		if candidate, count, ok := parseBreachedLine(scanner.Text()); ok && strings.EqualFold(candidate, hash) {
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// lineAt returns the first line starting after offset, with its start and the start of the line after it
+// At the end of the file start is the file size and the line is empty
+func lineAt(f *os.File, offset int64) (start, next int64, line string, err error) {
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+
+	skipped, err := reader.ReadString('\n')
+	if err == io.EOF {
+		return offset + int64(len(skipped)), offset + int64(len(skipped)), "", nil
+	}
+	if err != nil {
+		return 0, 0, "", err
+	}
+	start = offset + int64(len(skipped))
+
+	line, err = reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, 0, "", err
+	}
+	return start, start + int64(len(line)), strings.TrimRight(line, "\r\n"), nil
+}
+
+func parseBreachedLine(line string) (string, int, bool) {
+	hash, countText, found := strings.Cut(strings.TrimSpace(line), ":")
+	if !found {
+		return "", 0, false
+	}
+	count, err := strconv.Atoi(countText)
+	if err != nil {
+		return "", 0, false
+	}
+	return hash, count, true
+}
@@ -0,0 +1,177 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// PBKDF2 verifies imported PBKDF2 hashes, encoded as $pbkdf2-sha256$i=310000$salt$hash
+// sha1, sha256 and sha512 are supported, a missing i parameter is read from the legacy $pbkdf2-sha256$310000$salt$hash form
+type PBKDF2 struct {
+	Digest     string
+	Iterations int
+	SaltLength int
+	KeyLength  int
+}
+
+var pbkdf2Digests = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func (p *PBKDF2) Hash(password string) (string, error) {
+	digest, ok := pbkdf2Digests[p.Digest]
+	if !ok {
+		return "", fmt.Errorf("unsupported PBKDF2 digest %q", p.Digest)
+	}
+
+	salt, err := randomSalt(p.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := pbkdf2.Key(digest, password, salt, p.Iterations, p.KeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$pbkdf2-%s$i=%d$%s$%s", p.Digest, p.Iterations, encode(salt), encode(key)), nil
+}
+
+func (p *PBKDF2) Verify(password, encoded string) (bool, error) {
+	h, digest, iterations, err := parsePBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := pbkdf2.Key(digest, password, h.salt, iterations, len(h.hash))
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return equal(key, h.hash), nil
+}
+
+func (p *PBKDF2) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$pbkdf2-")
+}
+
+func (p *PBKDF2) Check(encoded string) error {
+	_, _, _, err := parsePBKDF2(encoded)
+	return err
+}
+
+func (p *PBKDF2) NeedsRehash(encoded string) bool {
+	h, _, iterations, err := parsePBKDF2(encoded)
+	return err != nil || h.id != "pbkdf2-"+p.Digest || iterations != p.Iterations
+}
+
+func parsePBKDF2(encoded string) (*phc, func() hash.Hash, int, error) {
+	h, err := parsePHC(normalizePBKDF2(encoded))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	digest, ok := pbkdf2Digests[strings.TrimPrefix(h.id, "pbkdf2-")]
+	if !ok {
+		return nil, nil, 0, ErrMalformedHash
+	}
+	iterations, err := h.intParam("i", maxPBKDF2Iterations)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return h, digest, iterations, nil
+}
+
+// normalizePBKDF2 turns the $pbkdf2-sha256$310000$salt$hash form into the PHC form
+func normalizePBKDF2(encoded string) string {
+	parts := strings.Split(encoded, "$")
+	if len(parts) == 5 && !strings.Contains(parts[2], "=") {
+		parts[2] = "i=" + parts[2]
+	}
+	return strings.Join(parts, "$")
+}
+
+// Scrypt verifies imported scrypt hashes, encoded as $scrypt$ln=15,r=8,p=1$salt$hash where N is 2^ln
+type Scrypt struct {
+	LogN       int
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+func (s *Scrypt) Hash(password string) (string, error) {
+	salt, err := randomSalt(s.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<s.LogN, s.R, s.P, s.KeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", s.LogN, s.R, s.P, encode(salt), encode(key)), nil
+}
+
+func (s *Scrypt) Verify(password, encoded string) (bool, error) {
+	h, logN, r, p, err := parseScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := scrypt.Key([]byte(password), h.salt, 1<<logN, r, p, len(h.hash))
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return equal(key, h.hash), nil
+}
+
+func (s *Scrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (s *Scrypt) Check(encoded string) error {
+	_, _, _, _, err := parseScrypt(encoded)
+	return err
+}
+
+func (s *Scrypt) NeedsRehash(encoded string) bool {
+	_, logN, r, p, err := parseScrypt(encoded)
+	return err != nil || logN != s.LogN || r != s.R || p != s.P
+}
+
+func parseScrypt(encoded string) (*phc, int, int, int, error) {
+	h, err := parsePHC(encoded)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if h.id != "scrypt" {
+		return nil, 0, 0, 0, ErrMalformedHash
+	}
+
+	logN, err := h.intParam("ln", 30)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	// Dividing instead of multiplying cannot overflow
+	r, err := h.intParam("r", maxScryptMemory/(128<<logN))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	p, err := h.intParam("p", maxScryptParallelism)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	return h, logN, r, p, nil
+}
@@ -0,0 +1,236 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bharabhi01/authservice/pkg/config"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unrecognized password hash format")
+	ErrMalformedHash = errors.New("malformed password hash")
+)
+
+// Highest cost parameters a hash may carry, hashes above them are rejected as malformed
+// Every login for a user verifies their hash, so an imported hash must not be able to use up memory or CPU
+const (
+	maxArgon2Memory      = 1 << 20 // KiB, 1 GiB
+	maxArgon2Iterations  = 10
+	maxArgon2Parallelism = 16
+	maxScryptMemory      = 1 << 30 // bytes, scrypt needs 128 * N * r
+	maxScryptParallelism = 16
+	maxPBKDF2Iterations  = 10000000
+	maxBcryptCost        = 16
+	// Longest salt or hash accepted, in bytes
+	maxHashLength = 128
+)
+
+// PasswordHasher hashes passwords into self-describing strings that carry the algorithm and its parameters
+type PasswordHasher interface {
+	// Hash returns the encoded hash of a password with a fresh salt
+	Hash(password string) (string, error)
+	// Verify checks a password against an encoded hash this hasher Identifies
+	Verify(password, encoded string) (bool, error)
+	// Identifies reports whether an encoded hash uses this hasher's algorithm
+	Identifies(encoded string) bool
+	// NeedsRehash reports whether an encoded hash was made with other parameters than the current ones
+	NeedsRehash(encoded string) bool
+	// Check returns ErrMalformedHash when an encoded hash this hasher Identifies cannot be verified,
+	// because it is malformed or its parameters are above the limits
+	Check(encoded string) error
+}
+
+// Manager hashes new passwords with the preferred hasher and verifies hashes of any known algorithm
+// Hashes made by another algorithm or with outdated parameters are reported for rehashing
+type Manager struct {
+	preferred PasswordHasher
+	hashers   []PasswordHasher
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+func NewManager(preferred PasswordHasher, others ...PasswordHasher) *Manager {
+	return &Manager{
+		preferred: preferred,
+		hashers:   append([]PasswordHasher{preferred}, others...),
+	}
+}
+
+// New creates the Manager selected by PASSWORD_HASH_ALGORITHM
+// Both argon2id and bcrypt hashes are always accepted, as are PBKDF2 and scrypt hashes imported from other systems
+func New(cfg *config.Config) (*Manager, error) {
+	if cfg.Argon2MemoryKB > maxArgon2Memory || cfg.Argon2Iterations > maxArgon2Iterations || cfg.Argon2Parallelism > maxArgon2Parallelism {
+		return nil, fmt.Errorf("argon2id parameters must be at most m=%d, t=%d, p=%d", maxArgon2Memory, maxArgon2Iterations, maxArgon2Parallelism)
+	}
+	if cfg.BcryptCost > maxBcryptCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be at most %d", maxBcryptCost)
+	}
+
+	argon := &Argon2id{
+		Memory:      uint32(cfg.Argon2MemoryKB),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcryptHasher := &Bcrypt{
+		Cost: cfg.BcryptCost,
+	}
+	legacy := []PasswordHasher{
+		&PBKDF2{Digest: "sha256", Iterations: 600000, SaltLength: 16, KeyLength: 32},
+		&Scrypt{LogN: 15, R: 8, P: 1, SaltLength: 16, KeyLength: 32},
+	}
+
+	switch cfg.PasswordHashAlgorithm {
+	case "argon2id", "":
+		return NewManager(argon, append([]PasswordHasher{bcryptHasher}, legacy...)...), nil
+	case "bcrypt":
+		return NewManager(bcryptHasher, append([]PasswordHasher{argon}, legacy...)...), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.PasswordHashAlgorithm)
+	}
+}
+
+// Hash hashes a password with the preferred hasher
+func (m *Manager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+// Verify checks a password against an encoded hash
+// rehash is set when the password matched but the hash should be replaced by a fresh one from Hash
+func (m *Manager) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	hasher := m.find(encoded)
+	if hasher == nil {
+		return false, false, ErrUnknownFormat
+	}
+
+	ok, err = hasher.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	return true, hasher != m.preferred || hasher.NeedsRehash(encoded), nil
+}
+
+// VerifyDummy checks password against a hash that belongs to nobody, made by the preferred hasher
+// Logins for unknown usernames call it so they take as long as wrong passwords for real ones
+func (m *Manager) VerifyDummy(password string) {
+	m.dummyOnce.Do(func() {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return
+		}
+		m.dummy, _ = m.preferred.Hash(base64.RawStdEncoding.EncodeToString(secret))
+	})
+
+	if m.dummy != "" {
+		m.preferred.Verify(password, m.dummy)
+	}
+}
+
+// Check returns why an encoded hash cannot be verified, used to check hashes before importing them
+// It is ErrUnknownFormat for unknown algorithms and ErrMalformedHash for hashes that are malformed or too costly
+func (m *Manager) Check(encoded string) error {
+	hasher := m.find(encoded)
+	if hasher == nil {
+		return ErrUnknownFormat
+	}
+	return hasher.Check(encoded)
+}
+
+func (m *Manager) find(encoded string) PasswordHasher {
+	for _, hasher := range m.hashers {
+		if hasher.Identifies(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// phc is a hash in the PHC string format: $id$params$salt$hash, with an optional v=N segment after the id
+type phc struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func parsePHC(encoded string) (*phc, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return nil, ErrMalformedHash
+	}
+
+	h := &phc{id: parts[1]}
+	parts = parts[2:]
+	if strings.HasPrefix(parts[0], "v=") {
+		h.version = strings.TrimPrefix(parts[0], "v=")
+		parts = parts[1:]
+	}
+	if len(parts) != 3 {
+		return nil, ErrMalformedHash
+	}
+
+	h.params = make(map[string]string)
+	for _, param := range strings.Split(parts[0], ",") {
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			return nil, ErrMalformedHash
+		}
+		h.params[key] = value
+	}
+
+	var err error
+	if h.salt, err = decode(parts[1]); err != nil || len(h.salt) > maxHashLength {
+		return nil, ErrMalformedHash
+	}
+	if h.hash, err = decode(parts[2]); err != nil || len(h.hash) == 0 || len(h.hash) > maxHashLength {
+		return nil, ErrMalformedHash
+	}
+
+	return h, nil
+}
+
+// intParam reads a numeric parameter of a PHC string, which must not be above max
+func (h *phc) intParam(key string, max int) (int, error) {
+	value, err := strconv.Atoi(h.params[key])
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%w: bad %s parameter", ErrMalformedHash, key)
+	}
+	if value > max {
+		return 0, fmt.Errorf("%w: %s parameter is above the limit of %d", ErrMalformedHash, key, max)
+	}
+	return value, nil
+}
+
+// decode accepts salts and hashes in standard base64 with or without padding
+// The adapted alphabet with "." instead of "+" used by passlib is accepted too
+func decode(s string) ([]byte, error) {
+	s = strings.ReplaceAll(strings.TrimRight(s, "="), ".", "+")
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func encode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func randomSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
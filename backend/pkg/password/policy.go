@@ -0,0 +1,178 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bharabhi01/authservice/pkg/config"
+)
+
+// Rules a password can violate, reported in Violation.Rule
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUppercase = "uppercase"
+	RuleLowercase = "lowercase"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleUserInfo  = "user_info"
+	RuleReused    = "reused"
+	RuleBreached  = "breached"
+)
+
+// Violation is a policy rule a candidate password does not satisfy
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Subject is the account a password is chosen for
+// History holds the hashes of its current and previous passwords, newest first
+type Subject struct {
+	Username string
+	Email    string
+	History  []string
+}
+
+// Policy decides which new passwords are acceptable
+// Lengths are counted in characters, MaxBytes in UTF-8 bytes for hashes that limit those, 0 means no limit
+// HistorySize is how many recent passwords cannot be reused
+type Policy struct {
+	MinLength        int
+	MaxLength        int
+	MaxBytes         int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	RejectUserInfo   bool
+	HistorySize      int
+	// Breached is consulted when set, passwords it contains are rejected
+	Breached *BreachedList
+	// hashes verifies candidates against the subject's history
+	hashes *Manager
+}
+
+// NewPolicy creates the policy configured by the PASSWORD_* settings
+// hashes verifies candidates against the password history
+func NewPolicy(cfg *config.Config, hashes *Manager) (*Policy, error) {
+	policy := &Policy{
+		MinLength:        cfg.PasswordMinLength,
+		MaxLength:        cfg.PasswordMaxLength,
+		RequireUppercase: cfg.PasswordRequireUppercase,
+		RequireLowercase: cfg.PasswordRequireLowercase,
+		RequireDigit:     cfg.PasswordRequireDigit,
+		RequireSymbol:    cfg.PasswordRequireSymbol,
+		RejectUserInfo:   cfg.PasswordRejectUserInfo,
+		HistorySize:      cfg.PasswordHistory,
+		hashes:           hashes,
+	}
+
+	// bcrypt refuses passwords longer than 72 bytes, which a password with fewer characters can already be
+	if cfg.PasswordHashAlgorithm == "bcrypt" {
+		policy.MaxBytes = 72
+	}
+
+	if cfg.BreachedPasswordsPath != "" {
+		breached, err := OpenBreachedList(cfg.BreachedPasswordsPath, cfg.BreachedPasswordsMinCount)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// Check returns every rule the candidate violates, or none if it is acceptable
+func (p *Policy) Check(candidate string, subject Subject) ([]Violation, error) {
+	violations := []Violation{}
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	length := utf8.RuneCountInString(candidate)
+	if length < p.MinLength {
+		add(RuleMinLength, "Password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(RuleMaxLength, "Password must be at most %d characters long", p.MaxLength)
+	} else if p.MaxBytes > 0 && len(candidate) > p.MaxBytes {
+		add(RuleMaxLength, "Password must be at most %d bytes long, characters outside ASCII take up to 4", p.MaxBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range candidate {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUppercase && !upper {
+		add(RuleUppercase, "Password must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !lower {
+		add(RuleLowercase, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add(RuleDigit, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add(RuleSymbol, "Password must contain a symbol")
+	}
+
+	if p.RejectUserInfo && containsUserInfo(candidate, subject) {
+		add(RuleUserInfo, "Password must not contain the username or email address")
+	}
+
+	if p.HistorySize > 0 && p.hashes != nil {
+		history := subject.History
+		if len(history) > p.HistorySize {
+			history = history[:p.HistorySize]
+		}
+		for _, encoded := range history {
+			if ok, _, err := p.hashes.Verify(candidate, encoded); err == nil && ok {
+				add(RuleReused, "Password must not be one of the last %d passwords", p.HistorySize)
+				break
+			}
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			add(RuleBreached, "Password appears in a list of breached passwords")
+		}
+	}
+
+	return violations, nil
+}
+
+// containsUserInfo reports whether the candidate contains the username, the email or the local part of the email
+// Parts shorter than 3 characters are ignored, they would reject too many passwords
+func containsUserInfo(candidate string, subject Subject) bool {
+	candidate = strings.ToLower(candidate)
+	email := strings.ToLower(subject.Email)
+	local, _, _ := strings.Cut(email, "@")
+
+	for _, part := range []string{strings.ToLower(subject.Username), email, local} {
+		if len(part) >= 3 && strings.Contains(candidate, part) {
+			return true
+		}
+	}
+	return false
+}
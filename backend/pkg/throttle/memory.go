@@ -0,0 +1,98 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// purgeInterval is how often a MemoryStore drops expired counters
+const purgeInterval = time.Minute
+
+// MemoryStore is an in-process Store
+// Counters are lost on restart and are not shared between replicas
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	Entry
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a store that drops expired counters every purgeInterval
+// Purging on a timer keeps a flood of failures from paying for a scan of every counter on each write
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		entries: make(map[string]memoryEntry),
+	}
+	go s.purgeEvery(purgeInterval)
+	return s
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, ttl time.Duration) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		entry = memoryEntry{}
+	}
+	before := entry.Entry
+
+	entry.Failures++
+	entry.LastFailure = now
+	entry.expiresAt = now.Add(ttl)
+	s.entries[key] = entry
+
+	return before, nil
+}
+
+func (s *MemoryStore) Forgive(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry.Failures--
+	if entry.Failures <= 0 {
+		delete(s.entries, key)
+		return nil
+	}
+	s.entries[key] = entry
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) purgeEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.purge(now)
+	}
+}
+
+// purge drops expired counters
+func (s *MemoryStore) purge(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
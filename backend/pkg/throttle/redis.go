@@ -0,0 +1,74 @@
+package throttle
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "throttle:"
+
+// RedisStore is a Store shared by every replica through Redis
+// Each counter is a hash with the failure count and the time of the last failure
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (s *RedisStore) Fail(ctx context.Context, key string, ttl time.Duration) (Entry, error) {
+	now := time.Now()
+
+	// The last failure is read inside the transaction, before it is overwritten
+	var lastFailure *redis.StringCmd
+	var failures *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		lastFailure = pipe.HGet(ctx, keyPrefix+key, "last_failure")
+		failures = pipe.HIncrBy(ctx, keyPrefix+key, "failures", 1)
+		pipe.HSet(ctx, keyPrefix+key, "last_failure", now.UnixNano())
+		pipe.Expire(ctx, keyPrefix+key, ttl)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return Entry{}, err
+	}
+	if err := failures.Err(); err != nil {
+		return Entry{}, err
+	}
+
+	before := Entry{
+		Failures: int(failures.Val()) - 1,
+	}
+	if lastFailure.Err() == nil {
+		nanos, err := strconv.ParseInt(lastFailure.Val(), 10, 64)
+		if err != nil {
+			return Entry{}, err
+		}
+		before.LastFailure = time.Unix(0, nanos)
+	}
+
+	return before, nil
+}
+
+// forgiveScript decrements a counter and deletes it once no failure is left, in one step
+var forgiveScript = redis.NewScript(`
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', -1)
+if failures <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+return failures
+`)
+
+func (s *RedisStore) Forgive(ctx context.Context, key string) error {
+	return forgiveScript.Run(ctx, s.client, []string{keyPrefix + key}).Err()
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, keyPrefix+key).Err()
+}
@@ -0,0 +1,177 @@
+package throttle
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Entry is the failure counter of a single key
+type Entry struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps failure counters for login attempts
+// A counter is forgotten once no failure was recorded for the ttl given to Fail
+type Store interface {
+	// Fail records a failed attempt for key and returns the counter as it was before, in one atomic step
+	Fail(ctx context.Context, key string, ttl time.Duration) (Entry, error)
+	// Forgive takes back one failure recorded for key
+	Forgive(ctx context.Context, key string) error
+	// Reset forgets the counter of key
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy decides how long attempts are held back after failures
+// The first FreeAttempts failures cost nothing, each one after that doubles the delay starting at BaseDelay
+// Reaching LockoutThreshold failures blocks attempts for LockoutDuration, a threshold of 0 disables the lockout
+type Policy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// wait returns how long after now the next attempt must wait, and whether that is a lockout
+func (p Policy) wait(e Entry, now time.Time) (time.Duration, bool) {
+	if e.Failures == 0 {
+		return 0, false
+	}
+
+	if p.LockoutThreshold > 0 && e.Failures >= p.LockoutThreshold {
+		if wait := e.LastFailure.Add(p.LockoutDuration).Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, false
+	}
+
+	if e.Failures <= p.FreeAttempts || p.BaseDelay <= 0 {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < e.Failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if wait := e.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// ttl is how long a counter must be kept for the policy to see it
+func (p Policy) ttl() time.Duration {
+	ttl := p.MaxDelay
+	if p.LockoutDuration > ttl {
+		ttl = p.LockoutDuration
+	}
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	return ttl
+}
+
+// Decision is the outcome of an attempt
+// Locked is set when the account itself is locked rather than slowed down,
+// LocksAccount when the account was not locked before the attempt, but is once the attempt counts as failed
+type Decision struct {
+	RetryAfter   time.Duration
+	Locked       bool
+	LocksAccount bool
+}
+
+// Allowed reports whether an attempt may go ahead
+func (d Decision) Allowed() bool {
+	return d.RetryAfter <= 0
+}
+
+// RetryAfterSeconds rounds RetryAfter up for the Retry-After header
+func (d Decision) RetryAfterSeconds() int {
+	return int(math.Ceil(d.RetryAfter.Seconds()))
+}
+
+// Limiter throttles login attempts per account and per client IP
+type Limiter struct {
+	store   Store
+	account Policy
+	ip      Policy
+}
+
+func NewLimiter(store Store, account, ip Policy) *Limiter {
+	return &Limiter{
+		store:   store,
+		account: account,
+		ip:      ip,
+	}
+}
+
+func accountKey(username string) string {
+	return "account:" + username
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Attempt counts a login attempt for username from ip as failed before the credentials are checked,
+// and decides from the failures before it whether it may go ahead
+// Counting first means concurrent attempts each see the ones made before them instead of all passing at once.
+// Attempts that are held back count too, so hammering only makes the wait longer.
+// An attempt whose credentials turn out right must be taken back with Release
+func (l *Limiter) Attempt(ctx context.Context, username, ip string) (Decision, error) {
+	now := time.Now()
+
+	account, err := l.store.Fail(ctx, accountKey(username), l.account.ttl())
+	if err != nil {
+		return Decision{}, err
+	}
+
+	client, err := l.store.Fail(ctx, ipKey(ip), l.ip.ttl())
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := l.decide(account, client, now)
+	_, lockedAfter := l.account.wait(Entry{Failures: account.Failures + 1, LastFailure: now}, now)
+	decision.LocksAccount = lockedAfter && !decision.Locked
+	return decision, nil
+}
+
+// Release takes back an attempt of username from ip whose credentials were right
+func (l *Limiter) Release(ctx context.Context, username, ip string) error {
+	if err := l.store.Forgive(ctx, accountKey(username)); err != nil {
+		return err
+	}
+	return l.store.Forgive(ctx, ipKey(ip))
+}
+
+// Succeed clears the failures of an account after a successful login
+// The IP counter is kept, so logging into an own account does not reset it for guessing others
+func (l *Limiter) Succeed(ctx context.Context, username string) error {
+	return l.store.Reset(ctx, accountKey(username))
+}
+
+// Unlock clears the failures and any lockout of an account
+func (l *Limiter) Unlock(ctx context.Context, username string) error {
+	return l.store.Reset(ctx, accountKey(username))
+}
+
+func (l *Limiter) decide(account, client Entry, now time.Time) Decision {
+	accountWait, locked := l.account.wait(account, now)
+	clientWait, _ := l.ip.wait(client, now)
+
+	decision := Decision{
+		RetryAfter: accountWait,
+		Locked:     locked,
+	}
+	if clientWait > decision.RetryAfter {
+		decision.RetryAfter = clientWait
+	}
+	return decision
+}
@@ -12,6 +12,9 @@ CREATE TABLE IF NOT EXISTS users (
     updated_at TIMESTAMP NOT NULL
 );
 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
 -- Roles table
 CREATE TABLE IF NOT EXISTS roles (
     id SERIAL PRIMARY KEY,
@@ -21,6 +24,12 @@ CREATE TABLE IF NOT EXISTS roles (
     updated_at TIMESTAMP NOT NULL
 );
 
+-- System roles are managed by this script and cannot be renamed or deleted through the API
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS is_system BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A role inherits every permission of its parent, e.g. editor has viewer as its parent
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES roles(id) ON DELETE SET NULL;
+
 -- Permissions table
 CREATE TABLE IF NOT EXISTS permissions (
     id SERIAL PRIMARY KEY,
@@ -46,15 +55,162 @@ CREATE TABLE IF NOT EXISTS user_roles (
     PRIMARY KEY (user_id, role_id)
 );
 
+-- Sessions table
+-- One row per login, access and refresh tokens reference it through their session ID
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    last_seen_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_last_seen_at ON sessions(last_seen_at);
+
 -- Refresh tokens table
+-- token holds the SHA-256 hash of the opaque refresh token, never the token itself
 CREATE TABLE IF NOT EXISTS refresh_tokens (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
     token VARCHAR(255) UNIQUE NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    session_id INTEGER REFERENCES sessions(id) ON DELETE CASCADE,
+    client_id VARCHAR(100) NOT NULL DEFAULT '',
+    scope TEXT NOT NULL DEFAULT '',
+    audience TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP NOT NULL,
+    rotated_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS audience TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_id INTEGER REFERENCES sessions(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+
+-- API keys table
+-- key_hash holds the SHA-256 hash of the key, prefix is kept to help users tell keys apart
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(255) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+-- TOTP multi-factor authentication table
+-- secret is encrypted with MFA_ENCRYPTION_KEY, enabled_at stays NULL until the user confirms a first code
+CREATE TABLE IF NOT EXISTS mfa_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    enabled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- MFA recovery codes table
+-- code_hash holds the SHA-256 hash of a one-time recovery code
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(255) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+
+-- Password history table
+-- Holds the hashes of replaced passwords so they cannot be reused
+CREATE TABLE IF NOT EXISTS password_history (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history(user_id, created_at);
+
+-- WebAuthn credentials table
+-- credential holds the credential record (public key, sign count, flags) as serialized by go-webauthn
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential JSONB NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- WebAuthn ceremony sessions table
+-- id holds the SHA-256 hash of the session ID handed to the browser, user_id is NULL for passkey logins
+CREATE TABLE IF NOT EXISTS webauthn_sessions (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    data JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- OAuth2 clients table
+-- secret_hash is empty for public clients, which must use PKCE
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(100) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    secret_hash VARCHAR(255) NOT NULL DEFAULT '',
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    grant_types TEXT[] NOT NULL DEFAULT '{}',
+    allowed_scopes TEXT[] NOT NULL DEFAULT '{}',
+    audiences TEXT[] NOT NULL DEFAULT '{}',
+    access_token_lifetime INTEGER NOT NULL DEFAULT 3600,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS audiences TEXT[] NOT NULL DEFAULT '{}';
+
+-- OAuth2 authorization codes table
+-- code holds the SHA-256 hash of the code handed to the client
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(255) UNIQUE NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    session_id INTEGER REFERENCES sessions(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    nonce TEXT NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL,
+    code_challenge_method VARCHAR(10) NOT NULL,
+    auth_time TIMESTAMP NOT NULL,
     expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL
 );
 
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS session_id INTEGER REFERENCES sessions(id) ON DELETE CASCADE;
+
 -- Audit logs table
 CREATE TABLE IF NOT EXISTS audit_logs (
     id SERIAL PRIMARY KEY,
@@ -68,6 +224,9 @@ CREATE TABLE IF NOT EXISTS audit_logs (
     created_at TIMESTAMP NOT NULL
 );
 
+-- actor_id is the admin behind entries written while impersonating user_id
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
 -- Insert default roles
 INSERT INTO roles (name, description, created_at, updated_at)
 VALUES
@@ -75,15 +234,22 @@ VALUES
     ('user', 'Regular user with limited permissions', NOW(), NOW())
 ON CONFLICT (name) DO NOTHING;
 
+UPDATE roles SET is_system = TRUE WHERE name IN ('admin', 'user');
+
 -- Insert default permissions
 INSERT INTO permissions (name, description, created_at, updated_at)
 VALUES
     ('user:read', 'Can read user information', NOW(), NOW()),
     ('user:write', 'Can create and update users', NOW(), NOW()),
     ('user:delete', 'Can delete users', NOW(), NOW()),
+    ('user:impersonate', 'Can act as another user', NOW(), NOW()),
     ('role:read', 'Can read role information', NOW(), NOW()),
     ('role:write', 'Can create and update roles', NOW(), NOW()),
-    ('role:delete', 'Can delete roles', NOW(), NOW())
+    ('role:delete', 'Can delete roles', NOW(), NOW()),
+    ('client:read', 'Can read OAuth2 clients', NOW(), NOW()),
+    ('client:write', 'Can create and update OAuth2 clients', NOW(), NOW()),
+    ('client:delete', 'Can delete OAuth2 clients', NOW(), NOW()),
+    ('audit:read', 'Can read audit logs', NOW(), NOW())
 ON CONFLICT (name) DO NOTHING;
 
 -- Assign all permissions to admin role
@@ -93,9 +259,66 @@ FROM roles r, permissions p
 WHERE r.name = 'admin'
 ON CONFLICT DO NOTHING;
 
--- Assign read permissions to user role
-INSERT INTO role_permissions (role_id, permission_id, created_at)
-SELECT r.id, p.id, NOW()
-FROM roles r, permissions p
-WHERE r.name = 'user' AND p.name LIKE '%:read'
-ON CONFLICT DO NOTHING;
\ No newline at end of file
+-- The user role gets no permissions, routes guarded by permissions are for administration
+-- Self-service endpoints under /users/me only need an authenticated user
+
+-- One-off data migrations that must not run again once applied
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    name VARCHAR(100) PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Earlier versions of this script granted every read permission to the user role, take back the ones that expose other users
+-- This runs once, so grants an operator adds back afterwards are kept
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'revoke_user_role_reads') THEN
+        DELETE FROM role_permissions
+        WHERE role_id IN (SELECT id FROM roles WHERE name = 'user')
+          AND permission_id IN (SELECT id FROM permissions WHERE name IN ('user:read', 'role:read'));
+
+        INSERT INTO schema_migrations (name) VALUES ('revoke_user_role_reads');
+    END IF;
+END $$;
+
+-- email_verified came after the first accounts, which never had a way to verify their address
+-- Those accounts are taken as verified once, otherwise they could no longer reset their password
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'backfill_email_verified') THEN
+        UPDATE users
+        SET email_verified = TRUE, email_verified_at = NOW()
+        WHERE email_verified = FALSE;
+
+        INSERT INTO schema_migrations (name) VALUES ('backfill_email_verified');
+    END IF;
+END $$;
+
+-- Roles used to be a single users.role column, user_roles is now the only record of them
+-- Each user's role is copied into user_roles once. The column is no longer read but stays for a release,
+-- so the previous version still finds a role after a rollback; a later release drops it
+-- A role name without a matching role would silently lose the user their role, so it stops the migration
+DO $$
+DECLARE
+    unmatched TEXT;
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'backfill_user_roles') THEN
+        SELECT string_agg(DISTINCT u.role, ', ') INTO unmatched
+        FROM users u
+        LEFT JOIN roles r ON r.name = u.role
+        WHERE r.id IS NULL;
+
+        IF unmatched IS NOT NULL THEN
+            RAISE EXCEPTION 'users.role holds roles that do not exist: %', unmatched
+                USING HINT = 'Create these roles or change the users that hold them, then run the script again';
+        END IF;
+
+        INSERT INTO user_roles (user_id, role_id, created_at)
+        SELECT u.id, r.id, NOW()
+        FROM users u
+        JOIN roles r ON r.name = u.role
+        ON CONFLICT DO NOTHING;
+
+        INSERT INTO schema_migrations (name) VALUES ('backfill_user_roles');
+    END IF;
+END $$;
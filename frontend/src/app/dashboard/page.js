@@ -20,7 +20,7 @@ export default function DashboardPage() {
                             <div className="border-t border-gray-200 pt-4">
                                 <h2 className="text-lg font-medium text-gray-900 mb-2">Welcome to your dashboard</h2>
                                 <p className="text-gray-600 mb-4">
-                                    You are logged in as <span className="font-medium">{user?.username}</span> with role <span className="font-medium">{user?.role || 'user'}</span>.
+                                    You are logged in as <span className="font-medium">{user?.username}</span> with roles <span className="font-medium">{user?.roles?.join(', ') || 'user'}</span>.
                                 </p>
 
                                 <div className="bg-gray-50 p-4 rounded-md">
@@ -36,7 +36,7 @@ export default function DashboardPage() {
                                             <span className="font-medium">Name:</span> {user?.first_name} {user?.last_name}
                                         </li>
                                         <li className="text-sm text-gray-600">
-                                            <span className="font-medium">Role:</span> {user?.role || 'user'}
+                                            <span className="font-medium">Roles:</span> {user?.roles?.join(', ') || 'user'}
                                         </li>
                                     </ul>
                                 </div>
@@ -136,8 +136,8 @@ export default function ProfilePage() {
                                             </div>
 
                                             <div>
-                                                <h3 className="text-sm font-medium text-gray-500">Role</h3>
-                                                <p className="mt-1 text-sm text-gray-900">{user?.role || 'user'}</p>
+                                                <h3 className="text-sm font-medium text-gray-500">Roles</h3>
+                                                <p className="mt-1 text-sm text-gray-900">{user?.roles?.join(', ') || 'user'}</p>
                                             </div>
 
                                             <div>
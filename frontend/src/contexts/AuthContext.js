@@ -14,19 +14,18 @@ export function AuthProvider({ children }) {
         if (typeof window === 'undefined')
             return;
 
+        // Without a stored token the session may still live in an HttpOnly cookie
         const token = localStorage.getItem('token');
-        if (!token) {
-            setLoading(false);
-            return;
-        }
 
         const fetchUser = async () => {
             try {
                 const response = await authAPI.getProfile();
                 setUser(response.user);
             } catch (err) {
-                console.error('Error fetching user profile:', err);
-                setError(err.message || 'Failed to fetch user profile');
+                if (token) {
+                    console.error('Error fetching user profile:', err);
+                    setError(err.message || 'Failed to fetch user profile');
+                }
                 localStorage.removeItem('token');
             } finally {
                 setLoading(false);
@@ -42,7 +41,9 @@ export function AuthProvider({ children }) {
 
         try {
             const response = await authAPI.register(userData);
-            localStorage.setItem('token', response.token);
+            if (response.token) {
+                localStorage.setItem('token', response.token);
+            }
             setUser(response.user);
         } catch (err) {
             setError(err.message || 'Registration failed');
@@ -58,7 +59,9 @@ export function AuthProvider({ children }) {
 
         try {
             const response = await authAPI.login(credentials);
-            localStorage.setItem('token', response.token);
+            if (response.token) {
+                localStorage.setItem('token', response.token);
+            }
             setUser(response.user);
         } catch (err) {
             setError(err.message || 'Login failed');
@@ -68,7 +71,12 @@ export function AuthProvider({ children }) {
         }
     };
 
-    const logout = () => {
+    const logout = async () => {
+        try {
+            await authAPI.logout();
+        } catch (err) {
+            console.error('Error logging out:', err);
+        }
         localStorage.removeItem('token');
         setUser(null);
     };
@@ -1,5 +1,13 @@
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_BASE_URL || 'http://localhost:8080/api/v1';
 
+function getCookie(name) {
+    if (typeof document === 'undefined')
+        return null;
+
+    const match = document.cookie.split('; ').find((row) => row.startsWith(`${name}=`));
+    return match ? decodeURIComponent(match.split('=')[1]) : null;
+}
+
 async function fetchAPI(url, options = {}) {
     const headers = {
         'Content-Type': 'application/json',
@@ -11,15 +19,27 @@ async function fetchAPI(url, options = {}) {
         headers['Authorization'] = `Bearer ${token}`;
     }
 
+    // In cookie mode the backend expects the CSRF cookie echoed back on state-changing requests
+    const method = (options.method || 'GET').toUpperCase();
+    const csrfToken = getCookie('csrf_token');
+    if (csrfToken && !['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+        headers['X-CSRF-Token'] = csrfToken;
+    }
+
     const response = await fetch(`${API_BASE_URL}${url}`, {
         ...options,
         headers,
+        credentials: 'include',
     });
 
     const data = await response.json();
 
     if (!response.ok) {
-        const error = new Error(data.error || 'Something went wrong');
+        // Password policy failures list every rule the password broke
+        const message = data.violations
+            ? data.violations.map((violation) => violation.message).join('. ')
+            : data.error;
+        const error = new Error(message || 'Something went wrong');
         error.status = response.status;
         error.data = data;
         throw error;
@@ -43,6 +63,12 @@ const authAPI = {
         });
     },
 
+    logout: () => {
+        return fetchAPI('/auth/logout', {
+            method: 'POST',
+        });
+    },
+
     getProfile: () => {
         return fetchAPI('/users/userinfo');
     },